@@ -1,20 +1,62 @@
 package pvwaAPI
 
 import (
-	// "bufio"
+	"bufio"
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/go-resty/resty/v2"
 	"golang.org/x/term"
+	"hash/fnv"
 	"io"
 	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
 
+// maxResultsPerPage is the largest page the recordings endpoint returns and
+// the page size GetRecordings starts with.
+const maxResultsPerPage = 1000
+
+// minPageSize is the smallest limit GetRecordings tries when the appliance
+// rejects the page size. A request rejected even then is taken to be bad
+// for another reason.
+const minPageSize = 10
+
+// maxResultsPerQuery is the number of recordings after which the API stops
+// returning results for a single query.
+const maxResultsPerQuery = 1000
+
+// progressInterval is the minimum time between two progress updates, so
+// large files don't flood the output with one line per chunk.
+const progressInterval = 250 * time.Millisecond
+
+// maxDrainBytes bounds how much of an unwanted streamed response body is
+// read before closing it. Small bodies are drained so the keep-alive
+// connection can be reused, larger ones are dropped with the connection.
+const maxDrainBytes = 64 * 1024
+
+// jsonWriteWorkers is the number of goroutines SaveToJSON writes the
+// per-recording files with, see BenchmarkSaveToJSONWriters before changing
+// it.
+const jsonWriteWorkers = 4
+
+// ndjsonBufferSize is the size of the write buffer of SaveToNDJSON.
+const ndjsonBufferSize = 64 << 10
+
+// jsonBuffers holds the encoding buffers of the SaveToJSON writers, so
+// every period after the first reuses the already grown buffers.
+var jsonBuffers = sync.Pool{New: func() any { return new(bytes.Buffer) }}
+
 // pvwaClient is a type that holds the relevant information for the program
 // see the field documentation
 // pvwaClient handles all communication with the PVWA API.
@@ -27,94 +69,503 @@ type pvwaClient struct {
 	Username string
 	// Authtoken is set automatically when calling NewPVWAConfig()
 	AuthToken string
+	// TokenLifetime is how long the appliance keeps an auth token valid.
+	// When set, the token is renewed by logging in again shortly before it
+	// expires. Zero never renews it
+	TokenLifetime time.Duration
+	// TokenSkewMargin is how long before the end of TokenLifetime the token
+	// is renewed, to absorb clock differences and requests in flight
+	TokenSkewMargin time.Duration
+	tokenMu         sync.Mutex
+	tokenIssued     time.Time
+	password        string
 	// the resty client will be reused between calls
 	Client *resty.Client
+	// PostDownloadHook is an optional command run after each successful
+	// download, see runPostDownloadHook for the arguments it receives
+	PostDownloadHook string
+	// PostDownloadHookFatal aborts DownloadRecordings when the hook fails
+	// instead of only logging the failure
+	PostDownloadHookFatal bool
+	// Workers is the number of recordings downloaded concurrently
+	Workers int
+	// KeepRawResponses stores the unparsed body of every page retrieved by
+	// GetRecordings in SessionRecordings.RawResponses
+	KeepRawResponses bool
+	// MaxConsecutiveFailures is the number of consecutive failed downloads
+	// that trips the circuit breaker. Zero stops at the first failure
+	MaxConsecutiveFailures int
+	// BreakerCooldown is how long downloads pause when the circuit breaker
+	// trips. Zero aborts the run instead
+	BreakerCooldown time.Duration
+	breakerOnce     sync.Once
+	breaker         *circuitBreaker
+	// PageSize is the limit sent with every page request. It starts at
+	// maxResultsPerPage and is lowered by GetRecordings when the appliance
+	// rejects it
+	PageSize int
+	// pageSizeDetected is set once a page was accepted with PageSize
+	pageSizeDetected bool
+	// WorkerRamp is the upper bound of the random delay each worker waits
+	// before its first download when more than one worker is used
+	WorkerRamp time.Duration
+	// StreamRetries is how many times a video download that breaks off
+	// mid-stream is restarted from the beginning before giving up
+	StreamRetries int
+	// Search is a free-text term added as the search parameter to every
+	// recordings query, narrowing the time windows on the server
+	Search string
+	// PlayTrailingSlash selects the Play URL: PlaySlashAlways posts to
+	// .../Play/, PlaySlashNever to .../Play. PlaySlashAuto (or empty)
+	// starts with the trailing slash and, when that is not found, tries
+	// without it; the first form that works is kept for the run
+	PlayTrailingSlash string
+	playPath          atomic.Value
+	// ActiveWindow, when set, restricts DownloadRecordings to starting
+	// downloads within the daily window; outside it the downloads pause
+	ActiveWindow *ActiveWindow
+	// Deadline, when set, is the time after which DownloadRecordings
+	// doesn't start new downloads
+	Deadline time.Time
+	// OnDownloaded is called after the video of a recording has been
+	// written, or was skipped because it already exists. It is called from
+	// the download workers and must be safe for concurrent use
+	OnDownloaded func(recording Recording)
 }
 
 // DownloadRecordings retrieves the video files for all recordings in the provided
 // SessionRecordings and saves them to the specified output directory.
-// Each recording is saved as an .avi file named with its SessionID.
-// The function handles large files by streaming the download in chunks.
-func (p *pvwaClient) DownloadRecordings(outputPath string, sessions *SessionRecordings) error {
+// Each recording is saved as an .avi file named with its SessionID, inside
+// a <SessionID> subdirectory when PerSessionDir is set. Existing files are
+// handled according to the Overwrite policy.
+// The function handles large files by streaming the download in chunks
+// into a <SessionID>.avi.partial file that is renamed once complete.
+// Downloads are spread over Workers concurrent workers; each worker waits a
+// random delay of up to WorkerRamp before its first request so connections
+// to the appliance open gradually.
+// By default the first error stops further downloads and is returned once
+// the in-flight ones have finished. With MaxConsecutiveFailures set, failed
+// recordings are skipped instead and reported together in a
+// *DownloadFailedError, unless the circuit breaker aborts the run.
+// If a PostDownloadHook is configured it is run after each successful download.
+// With an ActiveWindow no download is started outside the window; downloads
+// in flight when it closes are finished.
+// Likewise no download is started after the Deadline. The recordings left
+// are reported as failures with ErrDeadlineReached in a
+// *DownloadFailedError, so they can be retried.
+// The returned DownloadStats are valid on errors too.
+func (p *pvwaClient) DownloadRecordings(outputPath string, sessions *SessionRecordings) (DownloadStats, error) {
 	slog.Info("starting download of recordings",
 		"count", len(sessions.Recordings),
-		"path", outputPath)
+		"path", outputPath,
+		"workers", p.workerCount())
 
 	// Create the output directory
-	err := os.MkdirAll(outputPath, 0755)
+	err := os.MkdirAll(outputPath, DirMode)
 	if err != nil {
-		return fmt.Errorf("error creating output directory: %w", err)
+		return DownloadStats{}, fmt.Errorf("error creating output directory: %w", err)
 	}
 
-	for _, recording := range sessions.Recordings {
-		// Create the output file
-		filePath := filepath.Join(outputPath, recording.SessionID+".avi")
-		out, err := os.Create(filePath)
-		if err != nil {
-			return fmt.Errorf("error creating output file: %w", err)
+	progress := newProgressTracker(len(sessions.Recordings))
+	defer progress.close()
+	breaker := p.circuitBreaker()
+
+	jobs := make(chan Recording)
+	done := make(chan struct{})
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+		mu       sync.Mutex
+		failures []DownloadFailure
+	)
+	fail := func(err error) {
+		errOnce.Do(func() {
+			firstErr = err
+			close(done)
+		})
+	}
+
+	for i := 0; i < p.workerCount(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if p.workerCount() > 1 && p.WorkerRamp > 0 {
+				time.Sleep(rand.N(p.WorkerRamp))
+			}
+			for recording := range jobs {
+				breaker.wait(done)
+				err := p.processRecording(outputPath, recording, progress)
+				if err == nil {
+					breaker.success()
+					continue
+				}
+				if p.MaxConsecutiveFailures < 1 {
+					fail(err)
+					continue
+				}
+
+				slog.Error("download failed",
+					"sessionID", recording.SessionID,
+					"error", err)
+				mu.Lock()
+				failures = append(failures, DownloadFailure{SessionID: recording.SessionID, Err: err})
+				mu.Unlock()
+				if err := breaker.failure(); err != nil {
+					fail(err)
+				}
+			}
+		}()
+	}
+
+	stop, stopWatching := p.stopAtDeadline(done)
+	defer stopWatching()
+	var remaining []Recording
+dispatch:
+	for i, recording := range sessions.Recordings {
+		p.waitForActiveWindow(stop)
+		// Check stop first, a free worker mustn't win against it
+		select {
+		case <-stop:
+		default:
+			select {
+			case jobs <- recording:
+				continue
+			case <-stop:
+			}
 		}
-		defer out.Close()
+		// Only reported when stop wasn't caused by an error
+		remaining = sessions.Recordings[i:]
+		break dispatch
+	}
+	close(jobs)
+	wg.Wait()
 
-		// Make a streaming GET request
-		resp, err := p.Client.R().
-			SetDoNotParseResponse(true). // Important: don't parse response
-			SetHeader("Accept", "*/*").
-			SetHeader("authorization", p.AuthToken).
-			Post(p.BaseURL + "/recordings/" + recording.SessionID + "/Play/")
+	if len(remaining) > 0 && firstErr == nil {
+		slog.Warn("deadline reached, not starting the remaining downloads",
+			"deadline", p.Deadline.Round(time.Second),
+			"remaining", len(remaining))
+		for _, recording := range remaining {
+			failures = append(failures, DownloadFailure{SessionID: recording.SessionID, Err: ErrDeadlineReached})
+		}
+	}
 
-		if err != nil {
-			return fmt.Errorf("error making request: %w", err)
+	if firstErr != nil {
+		return progress.stats(), firstErr
+	}
+	if len(failures) > 0 {
+		return progress.stats(), &DownloadFailedError{Failures: failures}
+	}
+	return progress.stats(), nil
+}
+
+// circuitBreaker returns the client's circuit breaker, creating it on
+// first use.
+func (p *pvwaClient) circuitBreaker() *circuitBreaker {
+	p.breakerOnce.Do(func() {
+		p.breaker = &circuitBreaker{
+			threshold: p.MaxConsecutiveFailures,
+			cooldown:  p.BreakerCooldown,
 		}
+	})
+	return p.breaker
+}
 
-		// Check response status
-		if resp.StatusCode() != 200 {
-			return fmt.Errorf("unexpected status code: %d", resp.StatusCode())
+// processRecording downloads a single recording and runs the post-download
+// hook for it if one is configured.
+func (p *pvwaClient) processRecording(outputPath string, recording Recording, progress *progressTracker) error {
+	progress.start(recording.SessionID, recording.expectedSize())
+	filePath, err := p.downloadRecording(outputPath, recording, progress)
+	if errors.Is(err, ErrSkipped) {
+		progress.skip(recording.SessionID)
+		if p.OnDownloaded != nil {
+			p.OnDownloaded(recording)
 		}
+		return nil
+	}
+	progress.finish(recording.SessionID, filePath, err)
+	if err != nil {
+		return err
+	}
+	if p.OnDownloaded != nil {
+		p.OnDownloaded(recording)
+	}
 
-		// Close the response body when done
-		rawBody := resp.RawBody()
-		if rawBody == nil {
-			return fmt.Errorf("no response body received")
+	if p.PostDownloadHook != "" {
+		err := p.runPostDownloadHook(filePath, recording)
+		if err != nil {
+			if p.PostDownloadHookFatal {
+				return err
+			}
+			slog.Error("post-download hook failed",
+				"sessionID", recording.SessionID,
+				"error", err)
 		}
-		defer rawBody.Close()
+	}
 
-		buffer := make([]byte, 32*1024) // 32KB chunks
-		totalBytes := 0
+	return nil
+}
 
-		// Read and write in chunks
-		for {
-			n, err := rawBody.Read(buffer)
-			if n > 0 {
-				// Write the chunk to file
-				_, writeErr := out.Write(buffer[:n])
-				if writeErr != nil {
-					return fmt.Errorf("error writing to file: %v", writeErr)
-				}
-				totalBytes += n
+// workerCount returns the number of download workers to use, never less than one.
+func (p *pvwaClient) workerCount() int {
+	if p.Workers < 1 {
+		return 1
+	}
+	return p.Workers
+}
 
-				fmt.Printf("\r\tDownloading %s: %d bytes", recording.SessionID, totalBytes)
-			}
+// downloadRecording streams the video of a single recording into
+// outputPath and returns the path of the written file.
+func (p *pvwaClient) downloadRecording(outputDir string, recording Recording, progress *progressTracker) (string, error) {
+	dir, err := recordingDir(outputDir, recording)
+	if err != nil {
+		return "", err
+	}
 
-			if err == io.EOF {
-				break
+	// Resolve the final name first so skipped files don't cost a request
+	filePath, err := outputPath(filepath.Join(dir, recording.fileBase()+".avi"))
+	if err != nil {
+		return "", err
+	}
+
+	rawBody, err := p.openPlayStream(recording)
+	if err != nil {
+		return "", err
+	}
+	defer func() { rawBody.Close() }()
+
+	// Stream into a .partial file that is only renamed once the download
+	// is complete, so an interrupted download is never mistaken for a
+	// finished one. On failure the .partial file is left for inspection
+	// and is truncated by the next attempt, in WORM mode as well. With
+	// DirectWrite the final name is written directly and removed again if
+	// the download fails.
+	partialPath := filePath + ".partial"
+	create := createPartial
+	if DirectWrite {
+		partialPath, create = filePath, CreateFile
+	}
+	out, err := create(partialPath)
+	if err != nil {
+		return "", fmt.Errorf("error creating output file: %w", err)
+	}
+	defer out.Close()
+	complete := false
+	if DirectWrite {
+		defer func() {
+			if complete {
+				return
 			}
+			out.Close()
+			if err := os.Remove(filePath); err != nil {
+				slog.Error("could not remove incomplete download", "file", filePath, "error", err)
+			}
+		}()
+	}
+
+	// The Play endpoint doesn't support ranges, so a stream that breaks
+	// off is requested again from the start over a truncated file
+	var totalBytes int
+	for attempt := 0; ; attempt++ {
+		totalBytes, err = copyStream(out, rawBody, recording.SessionID, progress)
+		var interrupted *streamInterruptedError
+		if !errors.As(err, &interrupted) {
 			if err != nil {
-				return fmt.Errorf("error reading response: %v", err)
+				return "", err
 			}
+			break
+		}
+		if attempt >= p.StreamRetries {
+			if attempt > 0 {
+				return "", fmt.Errorf("giving up after %d attempts: %w", attempt+1, err)
+			}
+			return "", err
+		}
+		slog.Warn("download interrupted, restarting from the beginning",
+			"sessionID", recording.SessionID,
+			"bytes", totalBytes,
+			"retry", attempt+1,
+			"retries", p.StreamRetries,
+			"error", interrupted.err)
+		rawBody.Close()
+		if err := out.Truncate(0); err != nil {
+			return "", fmt.Errorf("error truncating output file: %w", err)
+		}
+		if _, err := out.Seek(0, io.SeekStart); err != nil {
+			return "", fmt.Errorf("error truncating output file: %w", err)
+		}
+		progress.start(recording.SessionID, recording.expectedSize())
+		rawBody, err = p.openPlayStream(recording)
+		if err != nil {
+			return "", err
 		}
+	}
+
+	if err := out.Close(); err != nil {
+		return "", fmt.Errorf("error closing output file: %w", err)
+	}
+	if !DirectWrite {
+		if err := finishPartial(partialPath, filePath); err != nil {
+			return "", fmt.Errorf("error finalizing output file: %w", err)
+		}
+	}
+	complete = true
+	RecordWritten(filePath)
 
-		slog.Info("download complete",
+	slog.Info("download complete",
+		"sessionID", recording.SessionID,
+		"bytes", totalBytes,
+		"file", filePath)
+
+	// Play streams the whole session, also when it is stored in several
+	// segments, so the stream is checked against all of them
+	if expected := recording.expectedSize(); expected > 0 && int64(totalBytes) != expected {
+		progress.mismatch()
+		slog.Warn("downloaded size differs from the size of the recording files, the video may be truncated",
 			"sessionID", recording.SessionID,
 			"bytes", totalBytes,
-			"file", filePath)
+			"expected", expected,
+			"segments", len(recording.RecordingFiles))
 	}
 
-	return nil
+	return filePath, nil
+}
+
+// openPlayStream requests the video of recording from the Play endpoint
+// and returns the response body. The caller must close it. Reverse proxies
+// differ in how they treat the trailing slash of the Play URL, so with
+// PlayTrailingSlash in auto mode a 404 is retried with the other form.
+func (p *pvwaClient) openPlayStream(recording Recording) (io.ReadCloser, error) {
+	paths := p.playPaths()
+	for i, path := range paths {
+		// Make a streaming request
+		resp, err := p.authorized(func(token string) (*resty.Response, error) {
+			return p.Client.R().
+				SetDoNotParseResponse(true). // Important: don't parse response
+				SetHeader("Accept", "*/*").
+				// The video is already compressed and streamed as is, so it is
+				// never requested gzip encoded
+				SetHeader("Accept-Encoding", "identity").
+				SetHeader("authorization", token).
+				Post(p.BaseURL + "/recordings/" + recording.SessionID + path)
+		})
+
+		if err != nil {
+			return nil, fmt.Errorf("error making request: %w", err)
+		}
+
+		rawBody := resp.RawBody()
+		if rawBody == nil {
+			return nil, fmt.Errorf("no response body received")
+		}
+
+		// Check response status
+		if resp.StatusCode() == 200 {
+			if len(paths) > 1 {
+				p.playPath.Store(path)
+			}
+			return rawBody, nil
+		}
+		// Keep the start of the error payload to explain the failure, and
+		// drain the rest so the connection goes back to the pool
+		payload, _ := io.ReadAll(io.LimitReader(rawBody, maxErrorPayload))
+		io.Copy(io.Discard, io.LimitReader(rawBody, maxDrainBytes))
+		rawBody.Close()
+		if final := resp.RawResponse.Request.URL; final.Host != resp.Request.RawRequest.URL.Host {
+			// A signed storage URL that failed says nothing about PVWA
+			return nil, withErrorDetail(fmt.Errorf("the Play request of recording %s was redirected to %s, which answered %s",
+				recording.SessionID, final.Host, resp.Status()), payload)
+		}
+		if resp.StatusCode() == http.StatusNotFound && i < len(paths)-1 {
+			slog.Debug("Play not found, retrying with the other form of the URL",
+				"sessionID", recording.SessionID,
+				"path", path)
+			continue
+		}
+		if resp.StatusCode() == http.StatusMethodNotAllowed {
+			// Play is the only documented download, see "Download flow" in
+			// the README
+			return nil, fmt.Errorf("the appliance doesn't accept the Play request of recording %s: %w",
+				recording.SessionID, withErrorDetail(p.responseError(resp), payload))
+		}
+		return nil, withErrorDetail(p.responseError(resp), payload)
+	}
+	return nil, fmt.Errorf("no Play URL to request")
+}
+
+// Forms of the Play URL, see pvwaClient.PlayTrailingSlash.
+const (
+	PlaySlashAuto   = "auto"
+	PlaySlashAlways = "always"
+	PlaySlashNever  = "never"
+)
+
+// ParsePlayTrailingSlash validates a value for PlayTrailingSlash.
+func ParsePlayTrailingSlash(value string) (string, error) {
+	switch value {
+	case PlaySlashAuto, PlaySlashAlways, PlaySlashNever:
+		return value, nil
+	}
+	return "", fmt.Errorf("invalid Play trailing slash %q, use 'auto', 'always' or 'never'", value)
+}
+
+// playPaths returns the endings of the Play URL to try in order. In auto
+// mode a form that worked before is used alone.
+func (p *pvwaClient) playPaths() []string {
+	switch p.PlayTrailingSlash {
+	case PlaySlashAlways:
+		return []string{"/Play/"}
+	case PlaySlashNever:
+		return []string{"/Play"}
+	}
+	if path, ok := p.playPath.Load().(string); ok {
+		return []string{path}
+	}
+	return []string{"/Play/", "/Play"}
+}
+
+// streamInterruptedError is returned by copyStream when the response body
+// fails mid-stream, as opposed to a failure writing the file.
+type streamInterruptedError struct {
+	err error
+}
+
+func (e *streamInterruptedError) Error() string {
+	return fmt.Sprintf("error reading response: %v", e.err)
+}
+
+func (e *streamInterruptedError) Unwrap() error {
+	return e.err
+}
+
+// copyStream copies body to out in chunks, reporting the progress of
+// sessionID, and returns the number of bytes written.
+func copyStream(out io.Writer, body io.Reader, sessionID string, progress *progressTracker) (int, error) {
+	buffer := make([]byte, 32*1024) // 32KB chunks
+	totalBytes := 0
+
+	// Read and write in chunks
+	for {
+		n, err := body.Read(buffer)
+		if n > 0 {
+			// Write the chunk to file
+			_, writeErr := out.Write(buffer[:n])
+			if writeErr != nil {
+				return totalBytes, fmt.Errorf("error writing to file: %v", writeErr)
+			}
+			totalBytes += n
+			progress.add(sessionID, n)
+		}
+
+		if err == io.EOF {
+			return totalBytes, nil
+		}
+		if err != nil {
+			return totalBytes, &streamInterruptedError{err: err}
+		}
+	}
 }
 
-// GetRecordings will set the Recordings type in pvwaClient with information about
-// recordings up to limit
-// Check the SessionRecording type to see what information is available
 // GetRecordings retrieves a list of recordings from the PVWA API based on the provided
 // query parameters. Common parameters include:
 //   - offset: Starting position for pagination
@@ -124,60 +575,206 @@ func (p *pvwaClient) DownloadRecordings(outputPath string, sessions *SessionReco
 //   - totime: End time as Unix timestamp
 //
 // The function automatically handles pagination for results over 1000 records.
+// Pages are requested with the client's PageSize as limit. Appliances that
+// reject the limit as too large answer with 400 Bad Request; until a page
+// has been accepted, the limit is then halved and the page retried, down
+// to minPageSize, and the working value is kept in PageSize for all
+// following requests. Any other 400, or one at the smallest limit, is
+// returned as an error and leaves PageSize unchanged.
+//
+// Appliances that return a nextLink with a page are paginated by following
+// the links, which must stay on the PVWA host, until a page comes without
+// one. A link that was already followed is an error, as the appliance
+// would serve the same pages forever. Otherwise the offset is advanced by
+// the page size.
+//
+// Retrieval ends with the first short page. Total may change between pages
+// while new sessions are recorded; the Total of the first page is returned
+// and bounds the retrieval, and a large change is logged as a warning.
 func (p *pvwaClient) GetRecordings(queryParams map[string]string) (*SessionRecordings, error) {
+	return p.getRecordings(queryParams, 0)
+}
+
+// getRecordings implements GetRecordings, stopping after max recordings
+// when max is greater than zero.
+func (p *pvwaClient) getRecordings(queryParams map[string]string, max int) (*SessionRecordings, error) {
+	queryParams = p.searchParams(queryParams)
 	slog.Info("retrieving recordings", "params", queryParams)
-	const maxResultsPerPage = 1000
 	allRecordings := &SessionRecordings{
 		Recordings: make([]Recording, 0),
 	}
+	if p.PageSize < 1 {
+		p.PageSize = maxResultsPerPage
+	}
+	pageSize := p.PageSize
 
 	// Start with offset 0
 	offset := 0
+	// Total can change between pages while sessions are being recorded,
+	// so the one reported with the first page is used throughout
+	snapshotTotal := -1
+	driftWarned := false
+	// nextLink is set once the API returns next links, which are followed
+	// instead of the offset from then on
+	nextLink := ""
+	followed := make(map[string]bool)
 	for {
 		// Update offset in query parameters
 		currentParams := make(map[string]string)
 		for k, v := range queryParams {
 			currentParams[k] = v
 		}
+		limit := p.PageSize
+		if max > 0 && max-len(allRecordings.Recordings) < limit {
+			limit = max - len(allRecordings.Recordings)
+		}
 		currentParams["offset"] = fmt.Sprintf("%d", offset)
+		currentParams["limit"] = fmt.Sprintf("%d", limit)
 
+		// resty reads the whole body and closes it before returning, on
+		// error responses too, so the connection is always released
 		var pageRecordings SessionRecordings
-		_, err := p.Client.R().
-			SetResult(&pageRecordings).
-			SetQueryParams(currentParams).
-			SetHeader("authorization", p.AuthToken).
-			Get(p.BaseURL + "/recordings")
+		resp, err := p.authorized(func(token string) (*resty.Response, error) {
+			req := p.Client.R().
+				SetResult(&pageRecordings).
+				SetHeader("authorization", token)
+			if nextLink != "" {
+				return req.Get(nextLink)
+			}
+			return req.SetQueryParams(currentParams).Get(p.BaseURL + "/recordings")
+		})
 
 		if err != nil {
 			return nil, fmt.Errorf("could not retrieve recordings at offset %d: %w", offset, err)
 		}
 
+		// The limit of a next link is chosen by the appliance, and once a
+		// page was accepted the limit isn't what the appliance rejects
+		if resp.StatusCode() == 400 && nextLink == "" && !p.pageSizeDetected {
+			if p.PageSize/2 >= minPageSize {
+				p.PageSize /= 2
+				slog.Warn("page size rejected by the API, retrying with a smaller limit",
+					"offset", offset,
+					"limit", p.PageSize)
+				continue
+			}
+			p.PageSize = pageSize
+		}
+		if resp.IsError() {
+			return nil, fmt.Errorf("could not retrieve recordings at offset %d: %w", offset, p.responseError(resp))
+		}
+		if !p.pageSizeDetected {
+			p.pageSizeDetected = true
+			slog.Info("using page size", "limit", p.PageSize)
+		}
+
 		slog.Info("retrieved page of recordings",
 			"offset", offset,
 			"count", len(pageRecordings.Recordings),
 			"total", pageRecordings.Total)
 
+		if snapshotTotal < 0 {
+			snapshotTotal = pageRecordings.Total
+		} else if !driftWarned && totalDrifted(snapshotTotal, pageRecordings.Total) {
+			driftWarned = true
+			slog.Warn("total number of recordings changed during retrieval, continuing with the first one",
+				"offset", offset,
+				"first", snapshotTotal,
+				"now", pageRecordings.Total)
+		}
+
 		// Add this page's recordings to our result
 		allRecordings.Recordings = append(allRecordings.Recordings, pageRecordings.Recordings...)
-		allRecordings.Total = pageRecordings.Total
+		allRecordings.Total = snapshotTotal
+		if p.KeepRawResponses {
+			allRecordings.RawResponses = append(allRecordings.RawResponses, json.RawMessage(resp.Body()))
+		}
+
+		if pageRecordings.NextLink != "" {
+			if len(pageRecordings.Recordings) == 0 {
+				break
+			}
+			nextLink, err = p.resolveNextLink(pageRecordings.NextLink)
+			if err != nil {
+				return nil, err
+			}
+			if followed[nextLink] {
+				return nil, fmt.Errorf("next link %q at offset %d was already followed, the pagination of the appliance loops", pageRecordings.NextLink, offset)
+			}
+			followed[nextLink] = true
+			offset += len(pageRecordings.Recordings)
+			if max > 0 && len(allRecordings.Recordings) >= max {
+				break
+			}
+			// As with offsets, never read past the total of the first page
+			if offset >= snapshotTotal {
+				break
+			}
+			continue
+		}
+		// Once paginating by next links, the last page has none
+		if nextLink != "" {
+			break
+		}
 
 		// If we got fewer results than the max, we're done
-		if len(pageRecordings.Recordings) < maxResultsPerPage {
+		if len(pageRecordings.Recordings) < limit {
+			break
+		}
+		if max > 0 && len(allRecordings.Recordings) >= max {
 			break
 		}
 
 		// Move to next page
-		offset += maxResultsPerPage
+		offset += p.PageSize
 
-		// Safety check: if we've retrieved more than the total, something's wrong
-		if offset >= pageRecordings.Total {
+		// Safety check against appliances that keep returning full pages:
+		// never read past the total of the first page
+		if offset >= snapshotTotal {
 			break
 		}
 	}
 
+	// Pages of next links are not limited to max
+	if max > 0 && len(allRecordings.Recordings) > max {
+		allRecordings.Recordings = allRecordings.Recordings[:max]
+	}
 	return allRecordings, nil
 }
 
+// resolveNextLink resolves the next link of a recordings page against the
+// recordings endpoint. Links to another host are rejected, since the auth
+// token is sent along.
+func (p *pvwaClient) resolveNextLink(link string) (string, error) {
+	base, err := url.Parse(p.BaseURL + "/recordings")
+	if err != nil {
+		return "", fmt.Errorf("invalid base URL: %w", err)
+	}
+	ref, err := url.Parse(link)
+	if err != nil {
+		return "", fmt.Errorf("invalid next link %q: %w", link, err)
+	}
+	next := base.ResolveReference(ref)
+	if next.Scheme != base.Scheme || next.Host != base.Host {
+		return "", fmt.Errorf("next link %q points to another host than %s", link, base.Host)
+	}
+	return next.String(), nil
+}
+
+// totalDriftThreshold is the relative change of Total between pages above
+// which getRecordings warns.
+const totalDriftThreshold = 0.1
+
+// totalDrifted reports whether total differs from first by more than
+// totalDriftThreshold.
+func totalDrifted(first int, total int) bool {
+	diff := total - first
+	if diff < 0 {
+		diff = -diff
+	}
+	return float64(diff) > float64(max(first, 1))*totalDriftThreshold
+}
+
 // GetAllRecordings retrieves recordings without filter.
 // Note that this will max out at 1000, if there are more
 // then use GetRecordingsByMonth
@@ -199,35 +796,242 @@ func (p *pvwaClient) GetAllRecordings() (*SessionRecordings, error) {
 	return r, nil
 }
 
-// GetRecordingsByMonth retrieves recordings for a specific month in 2024.
+// GetRecordingsBySearch retrieves the recordings matching the free-text
+// search term, across all time. The term is passed as the API's search
+// parameter, which the server matches against the properties of every
+// recording; use Search to combine it with the time windows of the other
+// methods instead.
+func (p *pvwaClient) GetRecordingsBySearch(term string) (*SessionRecordings, error) {
+	queryParams := map[string]string{
+		"offset": "0",
+		"sort":   "fromtime",
+		"order":  "asc",
+		"search": term,
+	}
+
+	r, err := p.GetRecordings(queryParams)
+	if err != nil {
+		return nil, fmt.Errorf("could not search recordings for %q: %w", term, err)
+	}
+
+	return r, nil
+}
+
+// searchParams returns queryParams with the client's Search term added,
+// unless it is empty or queryParams already has a search.
+func (p *pvwaClient) searchParams(queryParams map[string]string) map[string]string {
+	if _, ok := queryParams["search"]; ok || p.Search == "" {
+		return queryParams
+	}
+	params := make(map[string]string, len(queryParams)+1)
+	for k, v := range queryParams {
+		params[k] = v
+	}
+	params["search"] = p.Search
+	return params
+}
+
+// GetLatestRecordings retrieves the n most recent recordings, newest first.
+func (p *pvwaClient) GetLatestRecordings(n int) (*SessionRecordings, error) {
+	queryParams := map[string]string{
+		"offset": "0",
+		"sort":   "fromtime",
+		"order":  "desc",
+	}
+
+	r, err := p.getRecordings(queryParams, n)
+	if err != nil {
+		return nil, fmt.Errorf("could not get the latest recordings: %w", err)
+	}
+
+	return r, nil
+}
+
+// GetRecordingsByMonth retrieves recordings for a specific month of year.
 // The month parameter should be 1-12 representing the calendar month.
 // This method helps work around the 1000 record limit by breaking queries
 // into monthly chunks.
-func (p *pvwaClient) GetRecordingsByMonth(month int) (*SessionRecordings, error) {
+func (p *pvwaClient) GetRecordingsByMonth(year int, month int) (*SessionRecordings, error) {
+	r, err := p.GetRecordings(monthQueryParams(year, month))
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// CountRecordings returns the number of recordings matching queryParams
+// without retrieving them. A single minimal query (limit=1) is issued and
+// the Total reported by the API is returned.
+func (p *pvwaClient) CountRecordings(queryParams map[string]string) (int, error) {
+	currentParams := make(map[string]string)
+	for k, v := range p.searchParams(queryParams) {
+		currentParams[k] = v
+	}
+	currentParams["offset"] = "0"
+	currentParams["limit"] = "1"
+
+	var page SessionRecordings
+	resp, err := p.authorized(func(token string) (*resty.Response, error) {
+		return p.Client.R().
+			SetResult(&page).
+			SetQueryParams(currentParams).
+			SetHeader("authorization", token).
+			Get(p.BaseURL + "/recordings")
+	})
+
+	if err != nil {
+		return 0, fmt.Errorf("could not count recordings: %w", err)
+	}
+	if resp.IsError() {
+		return 0, fmt.Errorf("could not count recordings: %w", p.responseError(resp))
+	}
+
+	return page.Total, nil
+}
+
+// CountRecordingsByMonth returns the number of recordings for a specific
+// month of year, see GetRecordingsByMonth.
+func (p *pvwaClient) CountRecordingsByMonth(year int, month int) (int, error) {
+	return p.CountRecordings(monthQueryParams(year, month))
+}
+
+// GetRecordingsByRange retrieves the recordings between from and to
+// (inclusive) with a single paginated query, passing both times straight
+// through as the fromtime and totime parameters. Unlike
+// GetRecordingsComplete the window is never split, so dense windows are
+// subject to the API's result limit.
+func (p *pvwaClient) GetRecordingsByRange(from time.Time, to time.Time) (*SessionRecordings, error) {
+	r, err := p.GetRecordings(rangeQueryParams(from, to))
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// GetRecordingsComplete retrieves all recordings between from and to
+// (inclusive) regardless of how dense the window is. A query is truncated
+// when it returned fewer recordings than its Total, or stopped at exactly
+// maxResultsPerQuery; appliances that paginate past the limit return all
+// of them. A truncated window is bisected and both halves are retrieved
+// recursively until every chunk is complete. A one second window that is
+// still truncated can't be split and is an error, as some of its
+// recordings would be missing.
+func (p *pvwaClient) GetRecordingsComplete(from time.Time, to time.Time) (*SessionRecordings, error) {
+	from = from.Truncate(time.Second)
+	to = to.Truncate(time.Second)
+	if to.Before(from) {
+		return nil, fmt.Errorf("invalid range: %s is before %s", to, from)
+	}
+
+	r, err := p.GetRecordings(rangeQueryParams(from, to))
+	if err != nil {
+		return nil, err
+	}
+
+	truncated := r.Total > len(r.Recordings) || len(r.Recordings) == maxResultsPerQuery
+	if !truncated {
+		return r, nil
+	}
+	// Timestamps have a one second resolution, a window this small
+	// cannot be split any further
+	if !to.After(from) {
+		return nil, fmt.Errorf("%d of %d recordings starting at %s were returned, the API returns no more for a single query; narrow the query with a search term",
+			len(r.Recordings), max(r.Total, len(r.Recordings)), from)
+	}
+
+	mid := from.Add(to.Sub(from) / 2).Truncate(time.Second)
+	slog.Info("range reached the result limit, splitting",
+		"from", from,
+		"to", to,
+		"mid", mid,
+		"count", len(r.Recordings),
+		"total", r.Total)
+
+	left, err := p.GetRecordingsComplete(from, mid)
+	if err != nil {
+		return nil, err
+	}
+	right, err := p.GetRecordingsComplete(mid.Add(time.Second), to)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SessionRecordings{
+		Recordings:   append(left.Recordings, right.Recordings...),
+		Total:        left.Total + right.Total,
+		RawResponses: append(left.RawResponses, right.RawResponses...),
+	}, nil
+}
+
+// CountRecordingsByRange returns the number of recordings between from
+// and to (inclusive).
+func (p *pvwaClient) CountRecordingsByRange(from time.Time, to time.Time) (int, error) {
+	return p.CountRecordings(rangeQueryParams(from, to))
+}
+
+// monthQueryParams builds the query parameters selecting all recordings
+// of the given month of year.
+func monthQueryParams(year int, month int) map[string]string {
+	return rangeQueryParams(MonthRange(year, month))
+}
 
-	from := time.Date(2024, time.Month(month), 0, 0, 0, 0, 0, time.UTC)
+// MonthRange returns the window GetRecordingsByMonth queries for month of
+// year, from its first to its last second in UTC.
+func MonthRange(year int, month int) (time.Time, time.Time) {
+	from := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
 	to := from.AddDate(0, 1, 0).Add(-time.Second) // Last second of the month
+	return from, to
+}
 
-	queryParams := map[string]string{
+// rangeQueryParams builds the query parameters selecting all recordings
+// between from and to (inclusive).
+func rangeQueryParams(from time.Time, to time.Time) map[string]string {
+	return map[string]string{
 		"offset":   "0",
 		"sort":     "name",
 		"order":    "asc",
 		"fromtime": fmt.Sprintf("%d", from.Unix()),
 		"totime":   fmt.Sprintf("%d", to.Unix()),
 	}
+}
+
+// Ping checks that the appliance is responsive and accepts the auth token
+// by issuing a minimal authenticated request for a single recording. It is
+// meant to be called before a large export so an appliance in maintenance
+// is detected before any output is written. The HTTP version negotiated
+// with the appliance is logged.
+func (p *pvwaClient) Ping() error {
+	resp, err := p.authorized(func(token string) (*resty.Response, error) {
+		return p.Client.R().
+			SetQueryParams(map[string]string{"offset": "0", "limit": "1"}).
+			SetHeader("authorization", token).
+			Get(p.BaseURL + "/recordings")
+	})
 
-	r, err := p.GetRecordings(queryParams)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("PVWA at %s is not reachable: %w", p.BaseURL, err)
 	}
-
-	return r, nil
+	if resp.StatusCode() == 403 {
+		return p.responseError(resp)
+	}
+	if resp.IsError() {
+		return fmt.Errorf("PVWA at %s is not ready: %w", p.BaseURL, statusError(resp))
+	}
+	if HTTPVersion == "2" && resp.RawResponse.ProtoMajor < 2 && strings.HasPrefix(p.BaseURL, "https://") {
+		slog.Info("PVWA doesn't offer HTTP/2, falling back", "protocol", resp.Proto())
+	} else {
+		slog.Debug("connected to PVWA", "protocol", resp.Proto())
+	}
+	return nil
 }
 
 // GetAuthToken logins to the PVWA and returns an authorization token
 // GetAuthToken authenticates with the PVWA API using the client's username
 // and the provided password. On successful authentication, it stores the
-// returned auth token in the client for subsequent requests.
+// returned auth token in the client for subsequent requests, and keeps the
+// password in memory so the token can be renewed, see validToken.
 func (p *pvwaClient) GetAuthToken(password string) error {
 
 	authToken, err := p.Client.R().
@@ -238,8 +1042,20 @@ func (p *pvwaClient) GetAuthToken(password string) error {
 	if err != nil {
 		return fmt.Errorf("error obtaining authorization token: %w", err)
 	}
+	if authToken.IsError() {
+		// Client errors, like rejected credentials, won't go away by retrying
+		if authToken.StatusCode() < 500 {
+			return fmt.Errorf("error obtaining authorization token: %w: %w", errLogonRejected, statusError(authToken))
+		}
+		return fmt.Errorf("error obtaining authorization token: %w", statusError(authToken))
+	}
 	authTokenTrimmed := strings.Trim(string(authToken.Body()), "\"")
 	p.AuthToken = authTokenTrimmed
+	p.password = password
+	p.tokenIssued = time.Now()
+	slog.Debug("obtained auth token",
+		"issued", p.tokenIssued,
+		"lifetime", p.TokenLifetime)
 	return nil
 
 }
@@ -247,39 +1063,192 @@ func (p *pvwaClient) GetAuthToken(password string) error {
 // SaveToJSON saves the SessionRecordings structure to a JSON file
 // SaveToJSON writes each Recording in the SessionRecordings to a separate
 // JSON file in the specified directory. Each file is named using the
-// recording's SessionID with a .json extension. The directory will be
+// recording's SessionID with a .json extension, inside a <SessionID>
+// subdirectory when PerSessionDir is set. Existing files are handled
+// according to the Overwrite policy. The directory will be
 // created if it doesn't exist.
 func (s *SessionRecordings) SaveToJSON(dirname string) error {
+	return s.saveToJSON(dirname, jsonWriteWorkers)
+}
+
+// saveToJSON implements SaveToJSON with the given number of writers.
+func (s *SessionRecordings) saveToJSON(dirname string, writers int) error {
 	slog.Info("saving recordings to JSON",
 		"directory", dirname,
 		"count", len(s.Recordings))
 	// create directory if it doesn't exist
-	if err := os.MkdirAll(dirname, 0755); err != nil {
+	if err := os.MkdirAll(dirname, DirMode); err != nil {
 		return fmt.Errorf("error creating directory: %w", err)
 	}
-	// Convert the structure to JSON with proper indentation
+	if duplicates := s.duplicateSessionIDs(); len(duplicates) > 0 && !UniqueNames {
+		slog.Warn("recordings share a SessionID and will overwrite each other's files, use unique names to keep them apart",
+			"sessionIDs", duplicates)
+	}
+	// Spread the recordings over a few writers. Recordings sharing a file
+	// name always go to the same writer so their order is kept.
+	workers := min(writers, len(s.Recordings))
+	shards := make([][]Recording, workers)
 	for _, session := range s.Recordings {
-		jsonData, err := json.MarshalIndent(session, "", "    ")
-		if err != nil {
+		name := fnv.New32a()
+		name.Write([]byte(session.fileBase()))
+		shard := int(name.Sum32() % uint32(workers))
+		shards[shard] = append(shards[shard], session)
+	}
+
+	var wg sync.WaitGroup
+	var saved atomic.Int64
+	errs := make([]error, workers)
+	for i, shard := range shards {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = saveJSONFiles(dirname, shard, &saved)
+		}()
+	}
+	wg.Wait()
+
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	slog.Info("saved recordings JSON", "directory", dirname, "count", saved.Load())
+	return nil
+}
+
+// saveJSONFiles writes one indented JSON file per recording, reusing a
+// single buffer from jsonBuffers for the encoding, and counts the written
+// files in saved.
+func saveJSONFiles(dirname string, recordings []Recording, saved *atomic.Int64) error {
+	buf := jsonBuffers.Get().(*bytes.Buffer)
+	defer jsonBuffers.Put(buf)
+	encoder := json.NewEncoder(buf)
+	encoder.SetIndent("", "    ")
+	for _, session := range recordings {
+		buf.Reset()
+		if err := encoder.Encode(session); err != nil {
 			return fmt.Errorf("error marshaling to JSON: %w", err)
 		}
+		// Encode adds a newline MarshalIndent didn't, keep the files as they were
+		jsonData := bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+
+		dir, err := recordingDir(dirname, session)
+		if err != nil {
+			return err
+		}
 
 		// Write to file
-		filename := filepath.Join(dirname, session.SessionID+".json")
-		slog.Info("saved recording JSON", "file", filename)
-		err = os.WriteFile(filename, jsonData, 0644)
+		filename, err := outputPath(filepath.Join(dir, session.fileBase()+".json"))
+		if errors.Is(err, ErrSkipped) {
+			continue
+		}
 		if err != nil {
+			return err
+		}
+		if err := writeFile(filename, jsonData); err != nil {
 			return fmt.Errorf("error writing JSON to file: %w", err)
 		}
+		slog.Debug("saved recording JSON", "file", filename)
+		saved.Add(1)
+	}
+	return nil
+}
+
+// SaveToNDJSON writes all Recordings in the SessionRecordings to a single
+// recordings.ndjson file in the specified directory, one compact JSON
+// object per line. The fields are the same as the ones written by
+// SaveToJSON. The directory will be created if it doesn't exist.
+func (s *SessionRecordings) SaveToNDJSON(dirname string) error {
+	slog.Info("saving recordings to NDJSON",
+		"directory", dirname,
+		"count", len(s.Recordings))
+	if err := os.MkdirAll(dirname, DirMode); err != nil {
+		return fmt.Errorf("error creating directory: %w", err)
 	}
 
+	filename, err := outputPath(filepath.Join(dirname, "recordings.ndjson"))
+	if errors.Is(err, ErrSkipped) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	out, err := CreateFile(filename)
+	if err != nil {
+		return fmt.Errorf("error creating NDJSON file: %w", err)
+	}
+	defer out.Close()
+
+	// One write per recording would cost a system call each
+	w := bufio.NewWriterSize(out, ndjsonBufferSize)
+	if err := s.WriteNDJSON(w); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("error writing NDJSON: %w", err)
+	}
+
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("error writing NDJSON: %w", err)
+	}
+	RecordWritten(filename)
+	slog.Info("saved recordings NDJSON", "file", filename)
+	return nil
+}
+
+// WriteNDJSON writes all Recordings to w, one compact JSON object per line.
+func (s *SessionRecordings) WriteNDJSON(w io.Writer) error {
+	// Encode appends a newline after every value
+	encoder := json.NewEncoder(w)
+	for _, session := range s.Recordings {
+		if err := encoder.Encode(session); err != nil {
+			return fmt.Errorf("error writing NDJSON: %w", err)
+		}
+	}
+	return nil
+}
+
+// WriteJSON writes all Recordings to w as a stream of indented JSON
+// objects, formatted like the files written by SaveToJSON.
+func (s *SessionRecordings) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "    ")
+	for _, session := range s.Recordings {
+		if err := encoder.Encode(session); err != nil {
+			return fmt.Errorf("error writing JSON: %w", err)
+		}
+	}
+	return nil
+}
+
+// SaveRawResponses writes the unparsed API responses kept in RawResponses
+// to the specified directory as raw-response-0001.json, raw-response-0002.json
+// and so on, one file per retrieved page. This allows diffing what the
+// appliance returned against what the Recording struct captures.
+func (s *SessionRecordings) SaveRawResponses(dirname string) error {
+	if err := os.MkdirAll(dirname, DirMode); err != nil {
+		return fmt.Errorf("error creating directory: %w", err)
+	}
+	for i, raw := range s.RawResponses {
+		filename, err := outputPath(filepath.Join(dirname, fmt.Sprintf("raw-response-%04d.json", i+1)))
+		if errors.Is(err, ErrSkipped) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := writeFile(filename, raw); err != nil {
+			return fmt.Errorf("error writing raw response to file: %w", err)
+		}
+		slog.Info("saved raw response", "file", filename)
+	}
 	return nil
 }
 
 // NewPVWAConfig creates a new authenticated PVWA API client.
 // It requires a base URL for the API endpoint and a username.
-// The password will be read from the PVWA_PASSWORD environment variable,
-// or if not set, the user will be prompted to enter it securely.
+// The password will be read from PasswordFile when set, otherwise from the
+// PVWA_PASSWORD environment variable, or if not set, the user will be
+// prompted to enter it securely. When stdin
+// is not a terminal the prompt is impossible and an error is returned instead.
 // Returns an error if authentication fails or if required parameters are missing.
 func NewPVWAConfig(baseURL string, username string) (*pvwaClient, error) {
 	if baseURL == "" {
@@ -291,27 +1260,55 @@ func NewPVWAConfig(baseURL string, username string) (*pvwaClient, error) {
 	}
 
 	password := os.Getenv("PVWA_PASSWORD")
-	if password == "" {
-		fmt.Printf("Please enter password for user %s: ", username)
+	if PasswordFile != "" {
+		passwords, err := readPasswordFile()
+		if err != nil {
+			return nil, err
+		}
+		password = passwords[0]
+	} else if password == "" {
+		if !term.IsTerminal(int(syscall.Stdin)) {
+			return nil, fmt.Errorf("PVWA_PASSWORD is not set and stdin is not a terminal, " +
+				"so the password cannot be prompted for; set PVWA_PASSWORD or pass -password-file when running non-interactively (e.g. from cron)")
+		}
+		// Prompt on stderr so stdout stays usable for piping
+		fmt.Fprintf(os.Stderr, "Please enter password for user %s: ", username)
 		bytePassword, err := term.ReadPassword(int(syscall.Stdin))
 		if err != nil {
 			return nil, fmt.Errorf("error reading password: %w", err)
 		}
-		fmt.Println() // Add a newline after the password input
+		fmt.Fprintln(os.Stderr) // Add a newline after the password input
 		password = strings.TrimSpace(string(bytePassword))
 		if password == "" {
 			return nil, fmt.Errorf("password cannot be empty")
 		}
 	}
 
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid baseURL: %w", err)
+	}
+
 	pvwaConfig := &pvwaClient{
-		BaseURL:  baseURL,
-		Username: username,
-		Client:   resty.New(),
+		BaseURL:         baseURL,
+		Username:        username,
+		Client:          resty.New(),
+		TokenSkewMargin: defaultTokenSkewMargin,
+		password:        password,
 	}
+	pvwaConfig.Client.SetTransport(newTransport())
+	pvwaConfig.Client.SetTLSClientConfig(TLSConfig)
+	pvwaConfig.Client.SetRedirectPolicy(redirectPolicy(base))
+	useRequestIDs(pvwaConfig.Client)
+	for _, hook := range RequestHooks {
+		pvwaConfig.Client.OnBeforeRequest(hook)
+	}
+	// Ask for compressed metadata, resty decompresses it transparently.
+	// The download stream overrides this, see downloadRecording
+	pvwaConfig.Client.SetHeader("Accept-Encoding", "gzip")
+	pvwaConfig.Client.SetHeader("User-Agent", UserAgent)
 
-	err := pvwaConfig.GetAuthToken(password)
-	if err != nil {
+	if err := pvwaConfig.logon(); err != nil {
 		return nil, fmt.Errorf("could not get an authorization token %w", err)
 	}
 
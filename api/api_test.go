@@ -0,0 +1,475 @@
+package pvwaAPI
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestClient starts a stub PVWA that accepts any logon and serves
+// everything else with handler, and returns a client logged on to it.
+func newTestClient(t testing.TB, handler http.Handler) *pvwaClient {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/auth/CyberArk/Logon", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `"test-token"`)
+	})
+	mux.Handle("/", handler)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	t.Setenv("PVWA_PASSWORD", "test")
+	client, err := NewPVWAConfig(srv.URL, "test")
+	if err != nil {
+		t.Fatalf("NewPVWAConfig: %v", err)
+	}
+	return client
+}
+
+// writeRecordings answers with a page of recordings, numbered from first.
+func writeRecordings(w http.ResponseWriter, first int, count int, total int, nextLink string) {
+	page := SessionRecordings{Total: total, NextLink: nextLink}
+	for i := 0; i < count; i++ {
+		page.Recordings = append(page.Recordings, Recording{SessionID: fmt.Sprintf("s%05d", first+i)})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+func TestGetRecordingsRepeatedNextLink(t *testing.T) {
+	var requests atomic.Int32
+	client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := requests.Add(1)
+		if n > 10 {
+			t.Error("next link followed more than 10 times")
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+			return
+		}
+		writeRecordings(w, 0, 2, 1000000, "/recordings?page=2")
+	}))
+
+	_, err := client.GetRecordings(map[string]string{})
+	if err == nil || !strings.Contains(err.Error(), "already followed") {
+		t.Fatalf("GetRecordings with a repeated next link: got error %v, want already followed", err)
+	}
+	// The second page repeats the link of the first
+	if n := requests.Load(); n != 2 {
+		t.Errorf("got %d requests, want 2", n)
+	}
+}
+
+func TestGetRecordingsNextLinkBoundedByTotal(t *testing.T) {
+	var requests atomic.Int32
+	client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := requests.Add(1)
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		if n > 10 {
+			t.Error("next link followed more than 10 times")
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+			return
+		}
+		writeRecordings(w, page*2, 2, 6, fmt.Sprintf("/recordings?page=%d", page+1))
+	}))
+
+	recordings, err := client.GetRecordings(map[string]string{})
+	if err != nil {
+		t.Fatalf("GetRecordings: %v", err)
+	}
+	if n := requests.Load(); n != 3 {
+		t.Errorf("got %d requests, want 3", n)
+	}
+	if len(recordings.Recordings) != 6 {
+		t.Errorf("got %d recordings, want 6", len(recordings.Recordings))
+	}
+}
+
+// limitServer rejects pages larger than maxLimit and queries searching for
+// "bad" with 400 Bad Request, and serves a single short page otherwise.
+func limitServer(maxLimit int, requests *atomic.Int32) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		if limit > maxLimit || r.URL.Query().Get("search") == "bad" {
+			http.Error(w, `{"ErrorCode":"PASWS167E","ErrorMessage":"bad request"}`, http.StatusBadRequest)
+			return
+		}
+		writeRecordings(w, 0, 2, 2, "")
+	})
+}
+
+func TestGetRecordingsPageSizeRejected(t *testing.T) {
+	var requests atomic.Int32
+	client := newTestClient(t, limitServer(300, &requests))
+
+	if _, err := client.GetRecordings(map[string]string{}); err != nil {
+		t.Fatalf("GetRecordings: %v", err)
+	}
+	if client.PageSize != 250 {
+		t.Errorf("got page size %d, want 250", client.PageSize)
+	}
+	if n := requests.Load(); n != 3 {
+		t.Errorf("got %d requests, want 3", n)
+	}
+}
+
+func TestGetRecordingsBadRequest(t *testing.T) {
+	var requests atomic.Int32
+	client := newTestClient(t, limitServer(1000, &requests))
+
+	// Before any page was accepted the limit is ruled out first
+	_, err := client.GetRecordings(map[string]string{"search": "bad"})
+	if err == nil || !strings.Contains(err.Error(), "400") {
+		t.Fatalf("GetRecordings with a bad search: got error %v, want status 400", err)
+	}
+	if n := requests.Load(); n > 8 {
+		t.Errorf("got %d requests, want at most 8", n)
+	}
+	if client.PageSize != maxResultsPerPage {
+		t.Errorf("got page size %d after a bad request, want %d", client.PageSize, maxResultsPerPage)
+	}
+
+	// Once a page was accepted a 400 fails at once
+	if _, err := client.GetRecordings(map[string]string{}); err != nil {
+		t.Fatalf("GetRecordings: %v", err)
+	}
+	requests.Store(0)
+	_, err = client.GetRecordings(map[string]string{"search": "bad"})
+	if err == nil || !strings.Contains(err.Error(), "400") {
+		t.Fatalf("GetRecordings with a bad search: got error %v, want status 400", err)
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("got %d requests, want 1", n)
+	}
+	if client.PageSize != maxResultsPerPage {
+		t.Errorf("got page size %d after a bad request, want %d", client.PageSize, maxResultsPerPage)
+	}
+}
+
+func TestMonthRange(t *testing.T) {
+	tests := []struct {
+		year, month int
+		from, to    string
+	}{
+		{2024, 1, "2024-01-01T00:00:00Z", "2024-01-31T23:59:59Z"},
+		{2024, 2, "2024-02-01T00:00:00Z", "2024-02-29T23:59:59Z"},
+		{2025, 2, "2025-02-01T00:00:00Z", "2025-02-28T23:59:59Z"},
+		{2025, 12, "2025-12-01T00:00:00Z", "2025-12-31T23:59:59Z"},
+	}
+	for _, tt := range tests {
+		from, to := MonthRange(tt.year, tt.month)
+		if got := from.Format(time.RFC3339); got != tt.from {
+			t.Errorf("MonthRange(%d, %d) starts at %s, want %s", tt.year, tt.month, got, tt.from)
+		}
+		if got := to.Format(time.RFC3339); got != tt.to {
+			t.Errorf("MonthRange(%d, %d) ends at %s, want %s", tt.year, tt.month, got, tt.to)
+		}
+	}
+}
+
+// windowServer serves the recordings whose Start is within the fromtime
+// and totime of a query. Unless uncapped, at most maxResultsPerQuery of
+// them are served, with a Total that is either capped as well or, with
+// fullTotal, counts all of them.
+type windowServer struct {
+	starts    []int64
+	uncapped  bool
+	fullTotal bool
+}
+
+func (s windowServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	from, _ := strconv.ParseInt(query.Get("fromtime"), 10, 64)
+	to, _ := strconv.ParseInt(query.Get("totime"), 10, 64)
+	offset, _ := strconv.Atoi(query.Get("offset"))
+	limit, _ := strconv.Atoi(query.Get("limit"))
+
+	var matched []Recording
+	for i, start := range s.starts {
+		if start >= from && start <= to {
+			matched = append(matched, Recording{SessionID: fmt.Sprintf("s%05d", i), Start: start})
+		}
+	}
+	total := len(matched)
+	if !s.uncapped {
+		matched = matched[:min(len(matched), maxResultsPerQuery)]
+	}
+	if !s.fullTotal {
+		total = len(matched)
+	}
+	page := SessionRecordings{Total: total, Recordings: []Recording{}}
+	if offset < len(matched) {
+		page.Recordings = matched[offset:min(len(matched), offset+limit)]
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+// checkRetrievedOnce fails t unless recordings holds each of the
+// recordings of starts exactly once.
+func checkRetrievedOnce(t *testing.T, recordings *SessionRecordings, starts []int64) {
+	t.Helper()
+	seen := make(map[string]int)
+	for _, recording := range recordings.Recordings {
+		seen[recording.SessionID]++
+	}
+	for i := range starts {
+		if id := fmt.Sprintf("s%05d", i); seen[id] != 1 {
+			t.Errorf("recording %s retrieved %d times, want once", id, seen[id])
+		}
+	}
+	if len(recordings.Recordings) != len(starts) {
+		t.Errorf("got %d recordings, want %d", len(recordings.Recordings), len(starts))
+	}
+}
+
+func TestGetRecordingsCompleteBisects(t *testing.T) {
+	from := time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)
+	// 2500 recordings, three seconds apart, and a burst of 900 at one
+	// second that has to end up in a window of its own
+	var starts []int64
+	for i := 0; i < 2500; i++ {
+		starts = append(starts, from.Unix()+int64(i)*3)
+	}
+	for i := 0; i < 900; i++ {
+		starts = append(starts, from.Unix()+4000)
+	}
+	to := from.Add(3 * time.Hour)
+
+	for _, fullTotal := range []bool{false, true} {
+		t.Run(fmt.Sprintf("fullTotal=%t", fullTotal), func(t *testing.T) {
+			client := newTestClient(t, windowServer{starts: starts, fullTotal: fullTotal})
+			recordings, err := client.GetRecordingsComplete(from, to)
+			if err != nil {
+				t.Fatalf("GetRecordingsComplete: %v", err)
+			}
+			checkRetrievedOnce(t, recordings, starts)
+		})
+	}
+}
+
+func TestGetRecordingsCompletePaginatedSecond(t *testing.T) {
+	from := time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)
+	// All of them are paginated past the limit, so nothing is split
+	starts := make([]int64, maxResultsPerQuery+200)
+	for i := range starts {
+		starts[i] = from.Unix() + 60
+	}
+	var requests atomic.Int32
+	server := windowServer{starts: starts, uncapped: true, fullTotal: true}
+	client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		server.ServeHTTP(w, r)
+	}))
+	client.PageSize = 500
+
+	recordings, err := client.GetRecordingsComplete(from, from.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("GetRecordingsComplete: %v", err)
+	}
+	checkRetrievedOnce(t, recordings, starts)
+	if n := requests.Load(); n != 3 {
+		t.Errorf("got %d requests, want the 3 pages of a single query", n)
+	}
+}
+
+func TestGetRecordingsCompleteFullSecond(t *testing.T) {
+	from := time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)
+	starts := make([]int64, maxResultsPerQuery+200)
+	for i := range starts {
+		starts[i] = from.Unix() + 60
+	}
+	for _, fullTotal := range []bool{false, true} {
+		t.Run(fmt.Sprintf("fullTotal=%t", fullTotal), func(t *testing.T) {
+			client := newTestClient(t, windowServer{starts: starts, fullTotal: fullTotal})
+			_, err := client.GetRecordingsComplete(from, from.Add(time.Hour))
+			if err == nil {
+				t.Fatal("GetRecordingsComplete with a truncated second: got no error, want one")
+			}
+		})
+	}
+}
+
+// droppingPlayServer answers Play with the first bytes of a video and then
+// drops the connection, counting the requests in plays.
+func droppingPlayServer(t testing.TB, plays *atomic.Int32) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		plays.Add(1)
+		w.Header().Set("Content-Length", "1000000")
+		w.WriteHeader(http.StatusOK)
+		w.Write(make([]byte, 4096))
+		w.(http.Flusher).Flush()
+		conn, _, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Errorf("hijack: %v", err)
+			return
+		}
+		conn.Close()
+	})
+}
+
+func TestDownloadRecordingDroppedConnection(t *testing.T) {
+	for _, retries := range []int{0, 2} {
+		t.Run(fmt.Sprintf("retries=%d", retries), func(t *testing.T) {
+			var plays atomic.Int32
+			client := newTestClient(t, droppingPlayServer(t, &plays))
+			client.StreamRetries = retries
+			dir := t.TempDir()
+
+			_, err := client.downloadRecording(dir, Recording{SessionID: "s1"}, newProgressTracker(1))
+			if err == nil {
+				t.Fatal("downloadRecording over a dropped connection: got no error, want one")
+			}
+			if n := plays.Load(); n != int32(retries+1) {
+				t.Errorf("got %d Play requests, want %d", n, retries+1)
+			}
+			// The .partial file is left for inspection, holding only the
+			// last attempt as every retry truncates it
+			info, err := os.Stat(filepath.Join(dir, "s1.avi.partial"))
+			if err != nil {
+				t.Errorf("partial file: %v", err)
+			} else if info.Size() != 4096 {
+				t.Errorf("partial file has %d bytes, want 4096", info.Size())
+			}
+			if _, err := os.Stat(filepath.Join(dir, "s1.avi")); !os.IsNotExist(err) {
+				t.Errorf("final file of a failed download: got %v, want it not to exist", err)
+			}
+		})
+	}
+}
+
+func TestDownloadRecordingDroppedConnectionDirectWrite(t *testing.T) {
+	DirectWrite = true
+	t.Cleanup(func() { DirectWrite = false })
+	var plays atomic.Int32
+	client := newTestClient(t, droppingPlayServer(t, &plays))
+	dir := t.TempDir()
+
+	_, err := client.downloadRecording(dir, Recording{SessionID: "s1"}, newProgressTracker(1))
+	if err == nil {
+		t.Fatal("downloadRecording over a dropped connection: got no error, want one")
+	}
+	// The incomplete download is removed from its final name
+	if _, err := os.Stat(filepath.Join(dir, "s1.avi")); !os.IsNotExist(err) {
+		t.Errorf("final file of a failed download: got %v, want it not to exist", err)
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	tests := map[float64]string{
+		0:                "0.0 B",
+		1023:             "1023.0 B",
+		1536:             "1.5 KiB",
+		25 << 20:         "25.0 MiB",
+		3 << 40:          "3.0 TiB",
+		float64(5 << 50): "5120.0 TiB",
+	}
+	for bytes, want := range tests {
+		if got := FormatBytes(bytes); got != want {
+			t.Errorf("FormatBytes(%v) = %q, want %q", bytes, got, want)
+		}
+	}
+}
+
+// benchmarkRecordings returns n synthetic recordings with the fields that
+// are commonly set.
+func benchmarkRecordings(n int) *SessionRecordings {
+	start := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC).Unix()
+	recordings := &SessionRecordings{Total: n}
+	for i := 0; i < n; i++ {
+		recordings.Recordings = append(recordings.Recordings, Recording{
+			SessionID:     fmt.Sprintf("s%05d", i),
+			User:          fmt.Sprintf("user%d", i%50),
+			SafeName:      fmt.Sprintf("safe%d", i%10),
+			RemoteMachine: fmt.Sprintf("10.0.%d.%d", i/250%250, i%250),
+			Start:         start + int64(i)*60,
+			End:           start + int64(i)*60 + 600,
+			Duration:      600,
+		})
+	}
+	return recordings
+}
+
+// quietLogs discards the logs of the benchmarked functions until b ends.
+func quietLogs(b *testing.B) {
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	b.Cleanup(func() { slog.SetDefault(previous) })
+}
+
+// jsonFileBytes returns the number of bytes SaveToJSON writes for
+// recordings, as the throughput of its benchmarks.
+func jsonFileBytes(b *testing.B, recordings *SessionRecordings) int64 {
+	var size int64
+	for _, recording := range recordings.Recordings {
+		data, err := json.MarshalIndent(recording, "", "    ")
+		if err != nil {
+			b.Fatal(err)
+		}
+		size += int64(len(data))
+	}
+	return size
+}
+
+func BenchmarkSaveToJSON(b *testing.B) {
+	quietLogs(b)
+	recordings := benchmarkRecordings(10000)
+	dir := b.TempDir()
+	b.SetBytes(jsonFileBytes(b, recordings))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := recordings.SaveToJSON(dir); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkSaveToJSONWriters compares the number of writers of SaveToJSON,
+// jsonWriteWorkers is chosen from it.
+func BenchmarkSaveToJSONWriters(b *testing.B) {
+	quietLogs(b)
+	recordings := benchmarkRecordings(10000)
+	size := jsonFileBytes(b, recordings)
+	for _, writers := range []int{1, 2, 4, 8, 16} {
+		b.Run(fmt.Sprintf("writers=%d", writers), func(b *testing.B) {
+			dir := b.TempDir()
+			b.SetBytes(size)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := recordings.saveToJSON(dir, writers); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkSaveToNDJSON(b *testing.B) {
+	quietLogs(b)
+	recordings := benchmarkRecordings(10000)
+	dir := b.TempDir()
+	var ndjson bytes.Buffer
+	if err := recordings.WriteNDJSON(&ndjson); err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(ndjson.Len()))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := recordings.SaveToNDJSON(dir); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
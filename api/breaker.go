@@ -0,0 +1,106 @@
+package pvwaAPI
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DownloadFailure describes a recording that could not be downloaded.
+type DownloadFailure struct {
+	SessionID string
+	Err       error
+}
+
+// DownloadFailedError is returned by DownloadRecordings when some downloads
+// failed but, because MaxConsecutiveFailures allowed it, the remaining
+// recordings were still downloaded.
+type DownloadFailedError struct {
+	Failures []DownloadFailure
+}
+
+func (e *DownloadFailedError) Error() string {
+	ids := make([]string, len(e.Failures))
+	for i, f := range e.Failures {
+		ids[i] = f.SessionID
+	}
+	return fmt.Sprintf("%d downloads failed: %s", len(e.Failures), strings.Join(ids, ", "))
+}
+
+// circuitBreaker stops a run from hammering an appliance that keeps
+// failing. After threshold consecutive failures it opens: with a cooldown
+// all downloads pause until it has passed, after which the next result
+// decides between resuming (success) and aborting (failure); without a
+// cooldown the run is aborted straight away. It is shared by all workers
+// and all DownloadRecordings calls of a client.
+type circuitBreaker struct {
+	mu          sync.Mutex
+	threshold   int
+	cooldown    time.Duration
+	consecutive int
+	openUntil   time.Time
+	halfOpen    bool
+}
+
+// wait blocks while the breaker is open. It returns early when done is
+// closed.
+func (b *circuitBreaker) wait(done <-chan struct{}) {
+	b.mu.Lock()
+	remaining := time.Until(b.openUntil)
+	b.mu.Unlock()
+	if remaining <= 0 {
+		return
+	}
+
+	timer := time.NewTimer(remaining)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-done:
+	}
+}
+
+// success records a successful download.
+func (b *circuitBreaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.halfOpen {
+		slog.Info("circuit breaker closed, downloads recovered")
+	}
+	b.consecutive = 0
+	b.halfOpen = false
+}
+
+// failure records a failed download and returns an error once the run
+// has to be aborted.
+func (b *circuitBreaker) failure() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// Failures of downloads that were in flight while the breaker was
+	// open don't count
+	if time.Now().Before(b.openUntil) {
+		return nil
+	}
+	if b.halfOpen {
+		return fmt.Errorf("circuit breaker open: downloads still failing after a %s cooldown, aborting", b.cooldown)
+	}
+
+	b.consecutive++
+	if b.consecutive < b.threshold {
+		return nil
+	}
+	if b.cooldown <= 0 {
+		return fmt.Errorf("circuit breaker open: %d consecutive downloads failed, aborting", b.consecutive)
+	}
+
+	slog.Warn("circuit breaker open, pausing downloads",
+		"consecutiveFailures", b.consecutive,
+		"cooldown", b.cooldown)
+	b.openUntil = time.Now().Add(b.cooldown)
+	b.consecutive = 0
+	b.halfOpen = true
+	return nil
+}
@@ -0,0 +1,130 @@
+package pvwaAPI
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-resty/resty/v2"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+)
+
+// GetRecordingDetails retrieves the details of a single recording, which
+// hold more fields than the summary returned by the recordings list, and
+// returns the unparsed response.
+func (p *pvwaClient) GetRecordingDetails(sessionID string) (json.RawMessage, error) {
+	resp, err := p.authorized(func(token string) (*resty.Response, error) {
+		return p.Client.R().
+			SetHeader("authorization", token).
+			Get(p.BaseURL + "/recordings/" + sessionID)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve details of recording %s: %w", sessionID, err)
+	}
+	if resp.IsError() {
+		return nil, fmt.Errorf("could not retrieve details of recording %s: %w", sessionID, p.responseError(resp))
+	}
+	return json.RawMessage(resp.Body()), nil
+}
+
+// EnrichRecordings replaces the summary metadata of every recording with
+// the merged details from GetRecordingDetails, using Workers concurrent
+// requests. Fields of the details take precedence, fields only in the
+// summary are kept. A recording whose details can't be retrieved keeps its
+// summary and is logged; the first permission error stops the enrichment,
+// as every further request would be rejected the same way.
+func (p *pvwaClient) EnrichRecordings(sessions *SessionRecordings) error {
+	slog.Info("enriching recordings from their details", "count", len(sessions.Recordings))
+
+	indexes := make(chan int)
+	done := make(chan struct{})
+	var (
+		wg       sync.WaitGroup
+		failed   atomic.Int64
+		errOnce  sync.Once
+		firstErr error
+	)
+	for range min(p.workerCount(), max(len(sessions.Recordings), 1)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				select {
+				case <-done:
+					continue
+				default:
+				}
+				recording := &sessions.Recordings[i]
+				details, err := p.GetRecordingDetails(recording.SessionID)
+				if err == nil {
+					err = recording.mergeDetails(details)
+				}
+				if err == nil {
+					continue
+				}
+				var permErr *PermissionError
+				if errors.As(err, &permErr) {
+					errOnce.Do(func() {
+						firstErr = err
+						close(done)
+					})
+					continue
+				}
+				failed.Add(1)
+				slog.Warn("could not enrich recording, keeping its summary",
+					"sessionID", recording.SessionID,
+					"error", err)
+			}
+		}()
+	}
+dispatch:
+	for i := range sessions.Recordings {
+		select {
+		case indexes <- i:
+		case <-done:
+			break dispatch
+		}
+	}
+	close(indexes)
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	slog.Info("enriched recordings",
+		"enriched", len(sessions.Recordings)-int(failed.Load()),
+		"failed", failed.Load())
+	return nil
+}
+
+// mergeDetails overlays the fields of details on r, keeping the fields of
+// r that the details don't have.
+func (r *Recording) mergeDetails(details json.RawMessage) error {
+	summary, err := r.marshalAll()
+	if err != nil {
+		return fmt.Errorf("error marshaling recording %s: %w", r.SessionID, err)
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(summary, &fields); err != nil {
+		return fmt.Errorf("error marshaling recording %s: %w", r.SessionID, err)
+	}
+	var extra map[string]json.RawMessage
+	if err := json.Unmarshal(details, &extra); err != nil {
+		return fmt.Errorf("error parsing details of recording %s: %w", r.SessionID, err)
+	}
+	for key, value := range extra {
+		fields[key] = value
+	}
+
+	merged, err := json.Marshal(fields)
+	if err != nil {
+		return fmt.Errorf("error merging details of recording %s: %w", r.SessionID, err)
+	}
+	var enriched Recording
+	if err := json.Unmarshal(merged, &enriched); err != nil {
+		return fmt.Errorf("error merging details of recording %s: %w", r.SessionID, err)
+	}
+	*r = enriched
+	return nil
+}
@@ -0,0 +1,72 @@
+package pvwaAPI
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/go-resty/resty/v2"
+	"strings"
+)
+
+// maxErrorPayload is how much of the payload of a failed download is read
+// to explain the failure.
+const maxErrorPayload = 4 * 1024
+
+// maxErrorDetail is the length in characters, beyond which the explanation
+// taken from an error payload is cut off.
+const maxErrorDetail = 300
+
+// PermissionError is returned when PVWA answers a recordings request with
+// 403 Forbidden, which means the account may log in but is not allowed to
+// see PSM recordings.
+type PermissionError struct {
+	// Username is the account that was rejected
+	Username string
+	// RequestID is the X-Request-ID of the rejected request
+	RequestID string
+}
+
+func (e *PermissionError) Error() string {
+	return fmt.Sprintf("account %s lacks recordings/auditor permissions (403 Forbidden, request ID %s); "+
+		"it must be a member of the vault's Auditors group, or be granted the "+
+		"'List accounts' and 'Retrieve accounts' permissions on the safes holding the recordings",
+		e.Username, e.RequestID)
+}
+
+// responseError describes why resp is not a successful response, using a
+// *PermissionError for 403 Forbidden.
+func (p *pvwaClient) responseError(resp *resty.Response) error {
+	if resp.StatusCode() == 403 {
+		return &PermissionError{Username: p.Username, RequestID: requestID(resp)}
+	}
+	return statusError(resp)
+}
+
+// errorDetail extracts the reason for a failure from the start of an error
+// payload: ErrorCode and ErrorMessage of a PVWA error, otherwise the text
+// with its whitespace collapsed, cut off after maxErrorDetail characters.
+func errorDetail(payload []byte) string {
+	var pvwaError struct {
+		ErrorCode    string
+		ErrorMessage string
+	}
+	if json.Unmarshal(payload, &pvwaError) == nil && pvwaError.ErrorMessage != "" {
+		if pvwaError.ErrorCode == "" {
+			return pvwaError.ErrorMessage
+		}
+		return pvwaError.ErrorCode + ": " + pvwaError.ErrorMessage
+	}
+	detail := []rune(strings.Join(strings.Fields(strings.ToValidUTF8(string(payload), "")), " "))
+	if len(detail) > maxErrorDetail {
+		return string(detail[:maxErrorDetail]) + "..."
+	}
+	return string(detail)
+}
+
+// withErrorDetail adds the reason found in payload, if any, to err.
+func withErrorDetail(err error, payload []byte) error {
+	detail := errorDetail(payload)
+	if detail == "" {
+		return err
+	}
+	return fmt.Errorf("%w: %s", err, detail)
+}
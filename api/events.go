@@ -0,0 +1,55 @@
+package pvwaAPI
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// Events, when set, receives a DownloadEvent as one line of JSON for every
+// step of every download, so an external process can follow the run live.
+// Progress events are sent at most once per progressInterval per session.
+var Events io.Writer
+
+// eventsMu serializes writes to Events from the concurrent downloads.
+var eventsMu sync.Mutex
+
+// Download event types.
+const (
+	EventStarted   = "started"
+	EventRestarted = "restarted"
+	EventProgress  = "progress"
+	EventCompleted = "completed"
+	EventSkipped   = "skipped"
+	EventFailed    = "failed"
+)
+
+// DownloadEvent is one entry of the Events stream. Bytes is the number
+// of bytes received for the session so far, ExpectedBytes the size in the
+// metadata when the appliance reported one.
+type DownloadEvent struct {
+	Time          time.Time `json:"time"`
+	Event         string    `json:"event"`
+	SessionID     string    `json:"session_id"`
+	Bytes         int64     `json:"bytes"`
+	ExpectedBytes int64     `json:"expected_bytes,omitempty"`
+	File          string    `json:"file,omitempty"`
+	Error         string    `json:"error,omitempty"`
+}
+
+// emitEvent writes event to Events, if set. An event stream that can't be
+// written to must not fail the downloads, so write errors are ignored.
+func emitEvent(event DownloadEvent) {
+	if Events == nil {
+		return
+	}
+	event.Time = time.Now().UTC()
+	data, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+	Events.Write(append(data, '\n'))
+}
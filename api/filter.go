@@ -0,0 +1,150 @@
+package pvwaAPI
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// Filter returns a copy of s holding only the recordings for which keep
+// returns true. Total is left as reported by the API so the number of
+// filtered out recordings can still be derived.
+//
+// The By filters can't decide on a field the appliance didn't return (see
+// Recording.Reported), so they keep such recordings instead of dropping
+// them for a zero value.
+func (s *SessionRecordings) Filter(keep func(Recording) bool) *SessionRecordings {
+	filtered := &SessionRecordings{
+		Recordings:   make([]Recording, 0, len(s.Recordings)),
+		Total:        s.Total,
+		RawResponses: s.RawResponses,
+	}
+	for _, recording := range s.Recordings {
+		if keep(recording) {
+			filtered.Recordings = append(filtered.Recordings, recording)
+		}
+	}
+	return filtered
+}
+
+// ByConnectionComponent keeps recordings whose ConnectionComponentID is one
+// of components (e.g. PSM-RDP, PSM-SSH), compared case-insensitively.
+func ByConnectionComponent(components []string) func(Recording) bool {
+	return func(r Recording) bool {
+		if !r.Reported("ConnectionComponentID") {
+			return true
+		}
+		for _, c := range components {
+			if strings.EqualFold(r.ConnectionComponentID, c) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// BySeverity keeps recordings whose Severity is one of severities (e.g.
+// High, Medium, Low), compared case-insensitively.
+func BySeverity(severities []string) func(Recording) bool {
+	return func(r Recording) bool {
+		if !r.Reported("Severity") {
+			return true
+		}
+		for _, s := range severities {
+			if strings.EqualFold(r.Severity, s) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// ByRemoteMachine keeps recordings whose RemoteMachine is one of machines,
+// compared case-insensitively.
+func ByRemoteMachine(machines []string) func(Recording) bool {
+	return func(r Recording) bool {
+		if !r.Reported("RemoteMachine") {
+			return true
+		}
+		for _, m := range machines {
+			if strings.EqualFold(r.RemoteMachine, m) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// ByFromIP keeps recordings whose FromIP is one of addresses, each given as
+// a single IP address (10.0.0.5) or a CIDR prefix (10.0.0.0/24). Recordings
+// with a FromIP that isn't a valid address are never kept.
+func ByFromIP(addresses []string) (func(Recording) bool, error) {
+	var prefixes []netip.Prefix
+	for _, a := range addresses {
+		if strings.Contains(a, "/") {
+			prefix, err := netip.ParsePrefix(a)
+			if err != nil {
+				return nil, fmt.Errorf("invalid CIDR prefix %q: %w", a, err)
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(a)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IP address %q: %w", a, err)
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return func(r Recording) bool {
+		if !r.Reported("FromIP") {
+			return true
+		}
+		addr, err := netip.ParseAddr(strings.TrimSpace(r.FromIP))
+		if err != nil {
+			return false
+		}
+		// IPv4-mapped IPv6 addresses match IPv4 prefixes
+		addr = addr.Unmap()
+		for _, prefix := range prefixes {
+			if prefix.Contains(addr) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// ByMinRiskScore keeps recordings whose RiskScore is at least min.
+func ByMinRiskScore(min float64) func(Recording) bool {
+	return func(r Recording) bool {
+		return !r.Reported("RiskScore") || r.RiskScore >= min
+	}
+}
+
+// ByRiskScored keeps recordings the appliance returned a RiskScore for,
+// leaving out sessions that were never analyzed, including ones on
+// appliances that don't report risk scores at all.
+func ByRiskScored(r Recording) bool {
+	return r.Reported("RiskScore")
+}
+
+// BySessionIDs keeps recordings whose SessionID is one of ids.
+func BySessionIDs(ids []string) func(Recording) bool {
+	wanted := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		wanted[id] = true
+	}
+	return func(r Recording) bool {
+		return wanted[r.SessionID]
+	}
+}
+
+// ByMinDuration keeps recordings lasting at least min. Without a reported
+// Duration the time between Start and End is used.
+func ByMinDuration(min time.Duration) func(Recording) bool {
+	return func(r Recording) bool {
+		d, known := r.duration()
+		return !known || d >= min
+	}
+}
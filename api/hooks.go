@@ -0,0 +1,65 @@
+package pvwaAPI
+
+import (
+	"fmt"
+	"github.com/go-resty/resty/v2"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// RequestHooks are registered with Client.OnBeforeRequest of every client
+// created by NewPVWAConfig, in order and after the request ID middleware,
+// so they run for every request including the logon, and again for every
+// retry of it. They're meant for gateways in front of the appliance that
+// need extra headers or a signature on each request.
+//
+// A hook sees the request as built by this package: the full URL without
+// the query string, which is in r.QueryParam, the body, the request ID
+// and, except for the logon, the authorization header. Headers set on the
+// client (Accept-Encoding, User-Agent) are merged in after the hooks. An error
+// returned by a hook aborts the request with that error.
+var RequestHooks []resty.RequestMiddleware
+
+// runPostDownloadHook runs the configured PostDownloadHook for a downloaded
+// recording. The command line is split on whitespace and the path of the
+// downloaded file is appended as the last argument. Session metadata is
+// passed through the environment:
+//   - PVWA_RECORDING_FILE: path of the downloaded video
+//   - PVWA_SESSION_ID, PVWA_SESSION_GUID
+//   - PVWA_SAFE_NAME, PVWA_USER, PVWA_REMOTE_MACHINE
+//   - PVWA_START, PVWA_END: Unix timestamps of the session
+func (p *pvwaClient) runPostDownloadHook(filePath string, recording Recording) error {
+	args := strings.Fields(p.PostDownloadHook)
+	if len(args) == 0 {
+		return nil
+	}
+	args = append(args, filePath)
+
+	cmd := exec.Command(args[0], args[1:]...)
+	// Through the console, so the hook's output doesn't garble the status
+	// line, nor the metadata when it is written to stdout
+	cmd.Stdout = Console
+	cmd.Stderr = Console
+	cmd.Env = append(os.Environ(),
+		"PVWA_RECORDING_FILE="+filePath,
+		"PVWA_SESSION_ID="+recording.SessionID,
+		"PVWA_SESSION_GUID="+recording.SessionGuid,
+		"PVWA_SAFE_NAME="+recording.SafeName,
+		"PVWA_USER="+recording.User,
+		"PVWA_REMOTE_MACHINE="+recording.RemoteMachine,
+		"PVWA_START="+strconv.FormatInt(recording.Start, 10),
+		"PVWA_END="+strconv.FormatInt(recording.End, 10),
+	)
+
+	slog.Info("running post-download hook",
+		"sessionID", recording.SessionID,
+		"command", args[0])
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("post-download hook for session %s failed: %w", recording.SessionID, err)
+	}
+	return nil
+}
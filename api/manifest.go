@@ -0,0 +1,120 @@
+package pvwaAPI
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// written collects the paths of all files finished during the run.
+var written struct {
+	mu    sync.Mutex
+	paths []string
+}
+
+// RecordWritten adds path to the files listed by SaveManifest. The export
+// functions of this package record their files themselves, it only needs
+// to be called for files written elsewhere (e.g. archives).
+func RecordWritten(path string) {
+	written.mu.Lock()
+	defer written.mu.Unlock()
+	written.paths = append(written.paths, path)
+}
+
+// ManifestFile is a file listed in a run manifest.
+type ManifestFile struct {
+	Path   string `json:"Path"`
+	Size   int64  `json:"Size"`
+	SHA256 string `json:"SHA256"`
+}
+
+// Manifest lists every file written during one run.
+type Manifest struct {
+	RunID   string         `json:"RunID"`
+	Created time.Time      `json:"Created"`
+	Files   []ManifestFile `json:"Files"`
+}
+
+// SaveManifest writes manifest-<runID>.json to dir, listing the size and
+// SHA-256 of every file recorded with RecordWritten with paths relative to
+// dir. The manifest is sealed by a manifest-<runID>.json.sha256 file in
+// sha256sum format, so `sha256sum -c` verifies it has not been altered.
+// Files that no longer exist (e.g. archived and removed) are left out.
+func SaveManifest(dir string, runID string) (string, error) {
+	written.mu.Lock()
+	paths := append([]string(nil), written.paths...)
+	written.mu.Unlock()
+	sort.Strings(paths)
+
+	manifest := Manifest{
+		RunID:   runID,
+		Created: time.Now().UTC(),
+		Files:   make([]ManifestFile, 0, len(paths)),
+	}
+	for _, path := range paths {
+		file, err := manifestFile(dir, path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		manifest.Files = append(manifest.Files, file)
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "    ")
+	if err != nil {
+		return "", fmt.Errorf("error marshaling manifest: %w", err)
+	}
+	if err := os.MkdirAll(dir, DirMode); err != nil {
+		return "", fmt.Errorf("error creating directory: %w", err)
+	}
+
+	name := "manifest-" + runID + ".json"
+	manifestPath := filepath.Join(dir, name)
+	if err := writeFile(manifestPath, data); err != nil {
+		return "", fmt.Errorf("error writing manifest: %w", err)
+	}
+
+	sum := sha256.Sum256(data)
+	seal := fmt.Sprintf("%s  %s\n", hex.EncodeToString(sum[:]), name)
+	if err := writeFile(manifestPath+".sha256", []byte(seal)); err != nil {
+		return "", fmt.Errorf("error writing manifest seal: %w", err)
+	}
+
+	slog.Info("saved manifest", "file", manifestPath, "files", len(manifest.Files))
+	return manifestPath, nil
+}
+
+// manifestFile hashes the file at path for a manifest stored in dir.
+func manifestFile(dir string, path string) (ManifestFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return ManifestFile{}, err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, f)
+	if err != nil {
+		return ManifestFile{}, fmt.Errorf("error hashing %s: %w", path, err)
+	}
+
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		rel = path
+	}
+	return ManifestFile{
+		Path:   filepath.ToSlash(rel),
+		Size:   size,
+		SHA256: hex.EncodeToString(hash.Sum(nil)),
+	}, nil
+}
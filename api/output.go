@@ -0,0 +1,255 @@
+package pvwaAPI
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DirMode is the permission used for every directory created for the
+// export. Existing directories are left unchanged.
+var DirMode os.FileMode = 0755
+
+// FileMode is the permission used for every file created for the export.
+// As with any file creation the process umask is applied on top.
+var FileMode os.FileMode = 0644
+
+// WORM enables write-once mode for append-only (write once, read many)
+// storage: existing files are never truncated or replaced, an overwrite
+// that would be needed is an error instead, and a sealed manifest of the
+// run's files can be written with SaveManifest.
+var WORM bool
+
+// DirectWrite makes DownloadRecordings stream videos straight to their
+// final name instead of a .partial file that is renamed once complete,
+// for storage that doesn't allow renaming files.
+var DirectWrite bool
+
+// CreateFile creates or truncates the file at path using FileMode. In WORM
+// mode existing files are never truncated and an error is returned instead.
+func CreateFile(path string) (*os.File, error) {
+	if WORM {
+		return os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, FileMode)
+	}
+	return os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, FileMode)
+}
+
+// createPartial creates or truncates the .partial file of a download.
+// Unlike CreateFile it truncates in WORM mode too: a .partial file is only
+// ever left by a failed attempt, it was never finished nor sealed, and
+// refusing it would make every retry of the download fail.
+func createPartial(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, FileMode)
+}
+
+// finishPartial gives the completed .partial file at partialPath its final
+// name. In WORM mode an existing file at path is never replaced, the file
+// is linked to the final name, which fails if it exists, and only then is
+// the .partial name removed.
+func finishPartial(partialPath string, path string) error {
+	if !WORM {
+		return os.Rename(partialPath, path)
+	}
+	if err := os.Link(partialPath, path); err != nil {
+		return err
+	}
+	return os.Remove(partialPath)
+}
+
+// writeFile writes data to the file at path like os.WriteFile, but
+// through CreateFile, and records it for the manifest.
+func writeFile(path string, data []byte) error {
+	f, err := CreateFile(path)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	RecordWritten(path)
+	return nil
+}
+
+// OverwritePolicy decides what happens when an output file already exists.
+type OverwritePolicy string
+
+const (
+	// OverwriteAlways replaces existing files
+	OverwriteAlways OverwritePolicy = "overwrite"
+	// OverwriteSkip keeps existing files and skips writing them, for
+	// downloads this also skips the request
+	OverwriteSkip OverwritePolicy = "skip"
+	// OverwriteRename keeps existing files and writes the new one with a
+	// numeric suffix, e.g. 1234.avi becomes 1234-1.avi
+	OverwriteRename OverwritePolicy = "rename"
+)
+
+// Overwrite is the policy SaveToJSON, SaveToNDJSON, SaveRawResponses and
+// DownloadRecordings apply to existing files.
+var Overwrite = OverwriteAlways
+
+// ParseOverwritePolicy validates an overwrite policy name.
+func ParseOverwritePolicy(name string) (OverwritePolicy, error) {
+	switch policy := OverwritePolicy(name); policy {
+	case OverwriteAlways, OverwriteSkip, OverwriteRename:
+		return policy, nil
+	}
+	return "", fmt.Errorf("invalid overwrite policy %q, use 'skip', 'overwrite' or 'rename'", name)
+}
+
+// ErrSkipped is returned by OutputPath when the file exists and the
+// policy is OverwriteSkip.
+var ErrSkipped = errors.New("file already exists")
+
+// outputPath applies the Overwrite policy to path and returns the path
+// the file should be written to, or ErrSkipped if it must not be written.
+// In WORM mode replacing an existing file is an error.
+func outputPath(path string) (string, error) {
+	if !fileExists(path) {
+		return path, nil
+	}
+	if Overwrite == OverwriteAlways {
+		if WORM {
+			return "", fmt.Errorf("%s already exists and WORM mode forbids overwriting it", path)
+		}
+		return path, nil
+	}
+
+	if Overwrite == OverwriteSkip {
+		slog.Info("skipping existing file", "file", path)
+		return "", ErrSkipped
+	}
+
+	// Split the full extension so 1234.avi.partial style names stay intact
+	dir, name := filepath.Split(path)
+	base, ext, _ := strings.Cut(name, ".")
+	if ext != "" {
+		ext = "." + ext
+	}
+	for i := 1; ; i++ {
+		candidate := filepath.Join(dir, fmt.Sprintf("%s-%d%s", base, i, ext))
+		if !fileExists(candidate) {
+			slog.Info("file exists, writing to a new name", "file", path, "renamed", candidate)
+			return candidate, nil
+		}
+	}
+}
+
+// OutputPath applies the Overwrite policy to a file written outside this
+// package, like it is applied to the files written here, see outputPath.
+func OutputPath(path string) (string, error) {
+	return outputPath(path)
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// PerSessionDir makes SaveToJSON and DownloadRecordings write all files of a
+// recording into its own <SessionID> (see UniqueNames) subdirectory of the output directory
+// instead of directly into it.
+var PerSessionDir bool
+
+// PartitionByDate makes SaveToJSON and DownloadRecordings write the files of
+// a recording into a YYYY/MM/DD subdirectory of the output directory, taken
+// from the recording's Start in UTC, so the layout doesn't depend on how the
+// recordings were queried. The per-session directory goes below it.
+var PartitionByDate bool
+
+// UniqueNames makes file and directory names of a recording include its
+// SessionGuid as <SessionID>_<SessionGuid>, for appliances where the same
+// SessionID occurs more than once (e.g. across safes).
+var UniqueNames bool
+
+// fileBase returns the name, without extension, used for the files of a
+// recording.
+func (r Recording) fileBase() string {
+	if UniqueNames && r.SessionGuid != "" {
+		return r.SessionID + "_" + r.SessionGuid
+	}
+	return r.SessionID
+}
+
+// duplicateSessionIDs returns the SessionIDs that occur more than once.
+func (s *SessionRecordings) duplicateSessionIDs() []string {
+	seen := make(map[string]int)
+	var duplicates []string
+	for _, r := range s.Recordings {
+		seen[r.SessionID]++
+		if seen[r.SessionID] == 2 {
+			duplicates = append(duplicates, r.SessionID)
+		}
+	}
+	return duplicates
+}
+
+// indexEntry maps the file name of a recording back to the session.
+type indexEntry struct {
+	File        string `json:"File"`
+	SessionID   string `json:"SessionID"`
+	SessionGuid string `json:"SessionGuid"`
+	SafeName    string `json:"SafeName"`
+}
+
+// SaveIndex writes index.json to the specified directory, listing for
+// every recording the base name of its files together with the SessionID,
+// SessionGuid and safe it belongs to. This maps the names produced with
+// UniqueNames back to the sessions.
+func (s *SessionRecordings) SaveIndex(dirname string) error {
+	if err := os.MkdirAll(dirname, DirMode); err != nil {
+		return fmt.Errorf("error creating directory: %w", err)
+	}
+
+	index := make([]indexEntry, 0, len(s.Recordings))
+	for _, r := range s.Recordings {
+		index = append(index, indexEntry{
+			File:        r.fileBase(),
+			SessionID:   r.SessionID,
+			SessionGuid: r.SessionGuid,
+			SafeName:    r.SafeName,
+		})
+	}
+	data, err := json.MarshalIndent(index, "", "    ")
+	if err != nil {
+		return fmt.Errorf("error marshaling index: %w", err)
+	}
+
+	filename, err := outputPath(filepath.Join(dirname, "index.json"))
+	if errors.Is(err, ErrSkipped) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := writeFile(filename, data); err != nil {
+		return fmt.Errorf("error writing index: %w", err)
+	}
+	slog.Info("saved index", "file", filename)
+	return nil
+}
+
+// recordingDir returns the directory the files of recording are written to
+// below outputPath, creating it if needed.
+func recordingDir(outputPath string, recording Recording) (string, error) {
+	dir := outputPath
+	if PartitionByDate {
+		dir = filepath.Join(dir, time.Unix(recording.Start, 0).UTC().Format("2006/01/02"))
+	}
+	if PerSessionDir {
+		dir = filepath.Join(dir, recording.fileBase())
+	}
+	if err := os.MkdirAll(dir, DirMode); err != nil {
+		return "", fmt.Errorf("error creating directory: %w", err)
+	}
+	return dir, nil
+}
@@ -0,0 +1,189 @@
+package pvwaAPI
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/parquet-go/parquet-go"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// parquetRecording is the row written to Parquet files for a Recording.
+// Start and End are millisecond timestamps; fields without a fixed type,
+// like RecordedActivities and the extra API fields, are kept as JSON text.
+// Numbers the appliance didn't report are null.
+type parquetRecording struct {
+	SessionID             string                 `parquet:"SessionID"`
+	SessionGuid           string                 `parquet:"SessionGuid"`
+	SafeName              string                 `parquet:"SafeName,dict"`
+	FileName              string                 `parquet:"FileName"`
+	Start                 int64                  `parquet:"Start,timestamp(millisecond:utc)"`
+	End                   int64                  `parquet:"End,timestamp(millisecond:utc)"`
+	Duration              *int64                 `parquet:"Duration,optional"`
+	DurationHuman         string                 `parquet:"DurationHuman"`
+	User                  string                 `parquet:"User,dict"`
+	RemoteMachine         string                 `parquet:"RemoteMachine,dict"`
+	AccountUsername       string                 `parquet:"AccountUsername,dict"`
+	AccountPlatformID     string                 `parquet:"AccountPlatformID,dict"`
+	AccountAddress        string                 `parquet:"AccountAddress,dict"`
+	RecordedActivities    string                 `parquet:"RecordedActivities"`
+	ConnectionComponentID string                 `parquet:"ConnectionComponentID,dict"`
+	FromIP                string                 `parquet:"FromIP,dict"`
+	Client                string                 `parquet:"Client,dict"`
+	RiskScore             *float64               `parquet:"RiskScore,optional"`
+	Severity              string                 `parquet:"Severity,dict"`
+	RecordingFiles        []parquetRecordingFile `parquet:"RecordingFiles,list"`
+	VideoSize             *int64                 `parquet:"VideoSize,optional"`
+	TextSize              *int64                 `parquet:"TextSize,optional"`
+	DetailsUrl            string                 `parquet:"DetailsUrl"`
+	PlatformName          string                 `parquet:"PlatformName,dict"`
+	SessionType           string                 `parquet:"SessionType,dict"`
+	Extra                 string                 `parquet:"Extra"`
+}
+
+// parquetRecordingFile is the Parquet form of a RecordingFile.
+type parquetRecordingFile struct {
+	FileName           string `parquet:"FileName"`
+	RecordingType      int32  `parquet:"RecordingType"`
+	LastReviewBy       string `parquet:"LastReviewBy"`
+	LastReviewDate     int64  `parquet:"LastReviewDate,timestamp(millisecond:utc)"`
+	FileSize           int64  `parquet:"FileSize"`
+	CompressedFileSize int64  `parquet:"CompressedFileSize"`
+	Format             string `parquet:"Format"`
+}
+
+// newParquetRecording converts r to its Parquet row.
+func newParquetRecording(r Recording) (parquetRecording, error) {
+	row := parquetRecording{
+		SessionID:             r.SessionID,
+		SessionGuid:           r.SessionGuid,
+		SafeName:              r.SafeName,
+		FileName:              r.FileName,
+		Start:                 r.Start * 1000,
+		End:                   r.End * 1000,
+		DurationHuman:         r.DurationHuman(),
+		User:                  r.User,
+		RemoteMachine:         r.RemoteMachine,
+		AccountUsername:       r.AccountUsername,
+		AccountPlatformID:     r.AccountPlatformID,
+		AccountAddress:        r.AccountAddress,
+		ConnectionComponentID: r.ConnectionComponentID,
+		FromIP:                r.FromIP,
+		Client:                r.Client,
+		Severity:              r.Severity,
+		DetailsUrl:            r.DetailsUrl,
+		PlatformName:          r.PlatformName,
+		SessionType:           r.SessionType,
+	}
+	if r.Reported("Duration") {
+		row.Duration = ptr(int64(r.Duration))
+	}
+	if r.Reported("RiskScore") {
+		row.RiskScore = ptr(r.RiskScore)
+	}
+	if r.Reported("VideoSize") {
+		row.VideoSize = ptr(int64(r.VideoSize))
+	}
+	if r.Reported("TextSize") {
+		row.TextSize = ptr(int64(r.TextSize))
+	}
+	for _, f := range r.RecordingFiles {
+		row.RecordingFiles = append(row.RecordingFiles, parquetRecordingFile{
+			FileName:           f.FileName,
+			RecordingType:      int32(f.RecordingType),
+			LastReviewBy:       f.LastReviewBy,
+			LastReviewDate:     f.LastReviewDate * 1000,
+			FileSize:           f.FileSize,
+			CompressedFileSize: f.CompressedFileSize,
+			Format:             f.Format,
+		})
+	}
+	if r.RecordedActivities != nil {
+		data, err := json.Marshal(r.RecordedActivities)
+		if err != nil {
+			return row, fmt.Errorf("error marshaling recorded activities of %s: %w", r.SessionID, err)
+		}
+		row.RecordedActivities = string(data)
+	}
+	if len(r.Extra) > 0 {
+		data, err := json.Marshal(r.Extra)
+		if err != nil {
+			return row, fmt.Errorf("error marshaling extra fields of %s: %w", r.SessionID, err)
+		}
+		row.Extra = string(data)
+	}
+	return row, nil
+}
+
+// ptr returns a pointer to a copy of v.
+func ptr[T any](v T) *T {
+	return &v
+}
+
+// SaveToParquet writes the Recordings to Parquet files below dirname,
+// partitioned by the month the session started in (UTC):
+// dirname/month=2024-03/<name>.parquet. Each file holds the recordings of
+// a single month. The directories will be created if they don't exist.
+func (s *SessionRecordings) SaveToParquet(dirname string, name string) error {
+	slog.Info("saving recordings to Parquet",
+		"directory", dirname,
+		"count", len(s.Recordings))
+
+	partitions := make(map[string][]parquetRecording)
+	for _, r := range s.Recordings {
+		row, err := newParquetRecording(r)
+		if err != nil {
+			return err
+		}
+		month := time.Unix(r.Start, 0).UTC().Format("2006-01")
+		partitions[month] = append(partitions[month], row)
+	}
+
+	months := make([]string, 0, len(partitions))
+	for month := range partitions {
+		months = append(months, month)
+	}
+	sort.Strings(months)
+
+	for _, month := range months {
+		dir := filepath.Join(dirname, "month="+month)
+		if err := os.MkdirAll(dir, DirMode); err != nil {
+			return fmt.Errorf("error creating directory: %w", err)
+		}
+		filename, err := outputPath(filepath.Join(dir, name+".parquet"))
+		if errors.Is(err, ErrSkipped) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := writeParquet(filename, partitions[month]); err != nil {
+			return err
+		}
+		slog.Info("saved recordings Parquet", "file", filename, "count", len(partitions[month]))
+	}
+	return nil
+}
+
+// writeParquet writes rows to a new Parquet file at filename.
+func writeParquet(filename string, rows []parquetRecording) error {
+	out, err := CreateFile(filename)
+	if err != nil {
+		return fmt.Errorf("error creating Parquet file: %w", err)
+	}
+	defer out.Close()
+
+	schema := parquet.NewSchema("Recording", parquet.SchemaOf(parquetRecording{}))
+	if err := parquet.Write(out, rows, schema); err != nil {
+		return fmt.Errorf("error writing Parquet: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("error writing Parquet: %w", err)
+	}
+	RecordWritten(filename)
+	return nil
+}
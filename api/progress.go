@@ -0,0 +1,259 @@
+package pvwaAPI
+
+import (
+	"fmt"
+	"golang.org/x/term"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Console serializes everything written to the terminal. It keeps one
+// status line at the bottom that is cleared before and redrawn after every
+// other write, so concurrent downloads and log output don't interleave.
+// The status line is only drawn when the output is a terminal.
+// The structured logger should be configured to write through it.
+var Console = newConsole(os.Stdout)
+
+type console struct {
+	mu          sync.Mutex
+	out         io.Writer
+	interactive bool
+	status      string
+}
+
+func newConsole(w io.Writer) *console {
+	c := &console{}
+	c.SetOutput(w)
+	return c
+}
+
+// SetOutput changes where the console writes to.
+func (c *console) SetOutput(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.out = w
+	f, ok := w.(*os.File)
+	c.interactive = ok && term.IsTerminal(int(f.Fd()))
+}
+
+// Write writes p above the status line.
+func (c *console) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.eraseStatus()
+	n, err := c.out.Write(p)
+	if c.status != "" {
+		fmt.Fprint(c.out, "\r"+c.status)
+	}
+	return n, err
+}
+
+// setStatus replaces the status line with line.
+func (c *console) setStatus(line string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.interactive {
+		return
+	}
+
+	padding := ""
+	if len(c.status) > len(line) {
+		padding = strings.Repeat(" ", len(c.status)-len(line))
+	}
+	fmt.Fprint(c.out, "\r"+line+padding)
+	c.status = line
+}
+
+// clearStatus removes the status line.
+func (c *console) clearStatus() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.eraseStatus()
+	c.status = ""
+}
+
+// eraseStatus blanks the status line on screen, c.mu must be held.
+func (c *console) eraseStatus() {
+	if c.status != "" {
+		fmt.Fprint(c.out, "\r"+strings.Repeat(" ", len(c.status))+"\r")
+	}
+}
+
+// DownloadStats summarizes the outcome of a DownloadRecordings call.
+type DownloadStats struct {
+	// Downloaded is the number of videos written
+	Downloaded int
+	// Skipped is the number of videos not downloaded because they exist
+	Skipped int
+	// Failed is the number of downloads that failed
+	Failed int
+	// Bytes is the number of bytes received, including failed downloads
+	Bytes int64
+	// SizeMismatches is the number of downloaded videos whose size differs
+	// from the one in the metadata
+	SizeMismatches int
+}
+
+// progressTracker aggregates the progress of the concurrent downloads of a
+// single DownloadRecordings call and renders it as one status line.
+type progressTracker struct {
+	mu         sync.Mutex
+	total      int
+	done       int
+	skipped    int
+	failed     int
+	mismatched int
+	bytes      int64
+	active     map[string]int64 // bytes received per in-flight session
+	started    time.Time
+	lastRender time.Time
+	// expected and lastEvent hold the expected size and the time of the
+	// last progress event of every in-flight session, for Events
+	expected  map[string]int64
+	lastEvent map[string]time.Time
+}
+
+func newProgressTracker(total int) *progressTracker {
+	return &progressTracker{
+		total:     total,
+		active:    make(map[string]int64),
+		started:   time.Now(),
+		expected:  make(map[string]int64),
+		lastEvent: make(map[string]time.Time),
+	}
+}
+
+// start registers an in-flight download of expected bytes, zero when
+// unknown. Starting a session again, when its stream is restarted, resets
+// its byte count.
+func (t *progressTracker) start(sessionID string, expected int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	event := EventStarted
+	if _, ok := t.active[sessionID]; ok {
+		event = EventRestarted
+	}
+	t.active[sessionID] = 0
+	t.expected[sessionID] = expected
+	t.lastEvent[sessionID] = time.Now()
+	emitEvent(DownloadEvent{Event: event, SessionID: sessionID, ExpectedBytes: expected})
+	t.render(false)
+}
+
+// add records n more bytes received for sessionID.
+func (t *progressTracker) add(sessionID string, n int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.active[sessionID] += int64(n)
+	t.bytes += int64(n)
+	if Events != nil && time.Since(t.lastEvent[sessionID]) >= progressInterval {
+		t.lastEvent[sessionID] = time.Now()
+		emitEvent(DownloadEvent{
+			Event:         EventProgress,
+			SessionID:     sessionID,
+			Bytes:         t.active[sessionID],
+			ExpectedBytes: t.expected[sessionID],
+		})
+	}
+	t.render(false)
+}
+
+// finish marks the download of sessionID to file as done or failed.
+func (t *progressTracker) finish(sessionID string, file string, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	event := DownloadEvent{
+		Event:         EventCompleted,
+		SessionID:     sessionID,
+		Bytes:         t.active[sessionID],
+		ExpectedBytes: t.expected[sessionID],
+		File:          file,
+	}
+	t.forget(sessionID)
+	if err != nil {
+		t.failed++
+		event.Event, event.File, event.Error = EventFailed, "", err.Error()
+	} else {
+		t.done++
+	}
+	emitEvent(event)
+	t.render(true)
+}
+
+// skip marks sessionID as done without having been downloaded.
+func (t *progressTracker) skip(sessionID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.forget(sessionID)
+	t.done++
+	t.skipped++
+	emitEvent(DownloadEvent{Event: EventSkipped, SessionID: sessionID})
+	t.render(true)
+}
+
+// forget removes sessionID from the in-flight downloads. t.mu must be
+// held.
+func (t *progressTracker) forget(sessionID string) {
+	delete(t.active, sessionID)
+	delete(t.expected, sessionID)
+	delete(t.lastEvent, sessionID)
+}
+
+// mismatch records that the video of a finished download failed the size
+// check.
+func (t *progressTracker) mismatch() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.mismatched++
+}
+
+// stats returns the totals of the downloads tracked so far.
+func (t *progressTracker) stats() DownloadStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return DownloadStats{
+		Downloaded:     t.done - t.skipped,
+		Skipped:        t.skipped,
+		Failed:         t.failed,
+		Bytes:          t.bytes,
+		SizeMismatches: t.mismatched,
+	}
+}
+
+// close removes the status line once all downloads have ended.
+func (t *progressTracker) close() {
+	Console.clearStatus()
+}
+
+// render draws the status line, at most once per progressInterval unless
+// force is set. t.mu must be held.
+func (t *progressTracker) render(force bool) {
+	if !force && time.Since(t.lastRender) < progressInterval {
+		return
+	}
+	t.lastRender = time.Now()
+
+	rate := float64(t.bytes) / time.Since(t.started).Seconds()
+	line := fmt.Sprintf("Downloaded %d/%d files, %d active, %s, %s/s",
+		t.done, t.total, len(t.active), FormatBytes(float64(t.bytes)), FormatBytes(rate))
+	if t.failed > 0 {
+		line += fmt.Sprintf(", %d failed", t.failed)
+	}
+	Console.setStatus(line)
+}
+
+// FormatBytes formats a byte count, or a rate in bytes per second, using
+// binary units. All sizes shown to the user go through it.
+func FormatBytes(b float64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	i := 0
+	for b >= 1024 && i < len(units)-1 {
+		b /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.1f %s", b, units[i])
+}
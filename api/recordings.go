@@ -1,12 +1,29 @@
 package pvwaAPI
 
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
 // SessionRecordings represents a collection of PSM session recordings
 // retrieved from the PVWA API.
 type SessionRecordings struct {
 	// Recordings contains the list of individual recording sessions
 	Recordings []Recording `json:"Recordings"`
 	// Total is the count of all available recordings matching the query
-	Total      int         `json:"Total"`
+	Total int `json:"Total"`
+	// NextLink is the URL of the next page, returned by appliances that
+	// paginate by link instead of by offset
+	NextLink string `json:"nextLink,omitempty"`
+	// RawResponses holds the unparsed body of each retrieved page when the
+	// client was configured to keep them
+	RawResponses []json.RawMessage `json:"-"`
 }
 
 // Recording contains metadata about a single PSM recording session.
@@ -35,8 +52,244 @@ type Recording struct {
 	VideoSize             int             `json:"VideoSize"`
 	TextSize              int             `json:"TextSize"`
 	DetailsUrl            string          `json:"DetailsUrl"`
+	PlatformName          string          `json:"PlatformName"`
+	SessionType           string          `json:"SessionType"`
+	// Extra holds any field returned by the API that has no dedicated
+	// field above, so newer appliances don't lose metadata on export.
+	// The entries are written back as top-level fields by MarshalJSON.
+	Extra map[string]json.RawMessage `json:"-"`
+	// missing holds the dedicated fields the appliance didn't return (or
+	// returned as null), see Reported
+	missing map[string]bool
+}
+
+// dedicatedFields lists the JSON names of the dedicated Recording fields.
+var dedicatedFields = func() []string {
+	var names []string
+	t := reflect.TypeOf(Recording{})
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			names = append(names, name)
+		}
+	}
+	return names
+}()
+
+// recordingFields lists the JSON names of the dedicated Recording fields,
+// followed by the ones MarshalJSON computes.
+var recordingFields = append(slices.Clone(dedicatedFields), "DurationHuman")
+
+// Reported tells whether the appliance returned field, given by its JSON
+// name (e.g. RiskScore), for r. Older appliances omit fields such as
+// RiskScore, Severity or RecordingFiles, and the zero value left in the
+// struct must not be mistaken for a reported one. Recordings that weren't
+// decoded from JSON report all fields.
+func (r Recording) Reported(field string) bool {
+	return !r.missing[field]
+}
+
+// UnmarshalJSON decodes a recording and keeps every field without a
+// dedicated struct field in Extra. Computed fields written by MarshalJSON
+// are dropped. Dedicated fields that are absent or null are remembered as
+// not reported, see Reported.
+func (r *Recording) UnmarshalJSON(data []byte) error {
+	type plain Recording
+	if err := json.Unmarshal(data, (*plain)(r)); err != nil {
+		return err
+	}
+
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(data, &all); err != nil {
+		return err
+	}
+	reported := make(map[string]bool, len(dedicatedFields))
+	for key, value := range all {
+		for _, name := range recordingFields {
+			// encoding/json matches field names case-insensitively
+			if strings.EqualFold(key, name) {
+				if string(value) != "null" {
+					reported[name] = true
+				}
+				delete(all, key)
+				break
+			}
+		}
+	}
+
+	r.missing = nil
+	for _, name := range dedicatedFields {
+		if !reported[name] {
+			if r.missing == nil {
+				r.missing = make(map[string]bool)
+			}
+			r.missing[name] = true
+		}
+	}
+
+	r.Extra = nil
+	if len(all) > 0 {
+		r.Extra = all
+	}
+	return nil
+}
+
+// MarshalJSON encodes a recording with the entries of Extra appended as
+// top-level fields, in key order, after the dedicated ones and the
+// computed DurationHuman. Fields the appliance didn't report are left out
+// rather than written as zero values. When Fields is set only those fields
+// are written, in the order given.
+func (r Recording) MarshalJSON() ([]byte, error) {
+	data, err := r.marshalAll()
+	if err != nil || len(Fields) == 0 {
+		return data, err
+	}
+	return selectFields(data, Fields)
+}
+
+// marshalAll encodes all fields of a recording, see MarshalJSON.
+func (r Recording) marshalAll() ([]byte, error) {
+	type plain Recording
+	data, err := json.Marshal(struct {
+		plain
+		DurationHuman string `json:"DurationHuman"`
+	}{plain(r), r.DurationHuman()})
+	if err == nil && len(r.missing) > 0 {
+		data, err = selectFields(data, r.reportedFields())
+	}
+	if err != nil || len(r.Extra) == 0 {
+		return data, err
+	}
+
+	keys := make([]string, 0, len(r.Extra))
+	for key := range r.Extra {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	buf := bytes.NewBuffer(data[:len(data)-1]) // drop the closing brace
+	for _, key := range keys {
+		name, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteByte(',')
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(r.Extra[key])
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// Fields limits the metadata written for every recording to the named
+// top-level fields, see ParseFields. All fields are written when empty.
+var Fields []string
+
+// ParseFields validates a list of field names for Fields. Names of the
+// dedicated Recording fields are matched case-insensitively and returned
+// with their exported spelling. Other names are kept as given, as they
+// may refer to fields only newer appliances return (see Recording.Extra).
+func ParseFields(names []string) []string {
+	fields := make([]string, 0, len(names))
+	for _, name := range names {
+		known := false
+		for _, field := range recordingFields {
+			if strings.EqualFold(name, field) {
+				fields = append(fields, field)
+				known = true
+				break
+			}
+		}
+		if !known {
+			slog.Warn("not a known recording field, it is only written if the appliance returns it", "field", name)
+			fields = append(fields, name)
+		}
+	}
+	return fields
+}
+
+// selectFields reduces the JSON object data to fields, in that order.
+// Fields missing from data are left out.
+func selectFields(data []byte, fields []string) ([]byte, error) {
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for _, field := range fields {
+		value, ok := all[field]
+		if !ok {
+			continue
+		}
+		name, err := json.Marshal(field)
+		if err != nil {
+			return nil, err
+		}
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// reportedFields returns recordingFields without the ones r doesn't
+// report. DurationHuman is kept as long as the duration is known.
+func (r Recording) reportedFields() []string {
+	fields := make([]string, 0, len(recordingFields))
+	for _, name := range recordingFields {
+		if r.missing[name] {
+			continue
+		}
+		if _, known := r.duration(); name == "DurationHuman" && !known {
+			continue
+		}
+		fields = append(fields, name)
+	}
+	return fields
+}
+
+// duration returns how long the session lasted: Duration when reported,
+// otherwise the difference of End and Start. The second result is false
+// when neither is available.
+func (r Recording) duration() (time.Duration, bool) {
+	if r.Reported("Duration") {
+		return time.Duration(r.Duration) * time.Second, true
+	}
+	if r.Reported("Start") && r.Reported("End") && r.End >= r.Start {
+		return time.Duration(r.End-r.Start) * time.Second, true
+	}
+	return 0, false
+}
+
+// DurationHuman returns the duration of the session in a readable form
+// such as 1h23m45s, or an empty string when it isn't known.
+func (r Recording) DurationHuman() string {
+	d, ok := r.duration()
+	if !ok {
+		return ""
+	}
+	return d.String()
+}
+
+// expectedSize returns the sum of the FileSize of all RecordingFiles, or
+// zero when the appliance reported no sizes.
+func (r Recording) expectedSize() int64 {
+	var size int64
+	for _, f := range r.RecordingFiles {
+		size += f.FileSize
+	}
+	return size
 }
 
+// RecordingFile is one of the files a recording is stored in. Long
+// sessions can be split into several segments.
 type RecordingFile struct {
 	FileName           string `json:"FileName"`
 	RecordingType      int    `json:"RecordingType"`
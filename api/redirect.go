@@ -0,0 +1,64 @@
+package pvwaAPI
+
+import (
+	"errors"
+	"github.com/go-resty/resty/v2"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// maxRedirects is the number of redirects followed for a single request,
+// as many as net/http follows by default.
+const maxRedirects = 10
+
+// redirectHeaders are the only headers kept when a request is redirected
+// away from the appliance, e.g. from Play to a signed URL on external
+// object storage. The signature is in the URL, everything else (the auth
+// token, cookies, the Referer and headers added by RequestHooks) is meant
+// for PVWA only.
+var redirectHeaders = []string{"Accept", "Accept-Encoding", "User-Agent"}
+
+// redirectPolicy follows redirects like net/http, but treats every host
+// other than the one of base as a third party: unlike net/http, which
+// still sends the Authorization header to subdomains, and to the same host
+// over plain HTTP, only redirectHeaders are sent there. Redirected
+// requests are sent as GET, as signed URLs serve the video to GET only.
+func redirectPolicy(base *url.URL) resty.RedirectPolicy {
+	return resty.RedirectPolicyFunc(func(req *http.Request, via []*http.Request) error {
+		if len(via) >= maxRedirects {
+			return errors.New("stopped after 10 redirects")
+		}
+		if sameOrigin(req.URL, base) {
+			return nil
+		}
+
+		kept := make(http.Header, len(redirectHeaders))
+		for _, name := range redirectHeaders {
+			if values := req.Header.Values(name); len(values) > 0 {
+				kept[name] = values
+			}
+		}
+		req.Header = kept
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			// A 307 or 308 would repeat the POST of Play with its body
+			req.Method = http.MethodGet
+			req.Body, req.GetBody, req.ContentLength = nil, nil, 0
+		}
+		// The query of a signed URL is a credential, so it isn't logged
+		slog.Debug("following redirect without PVWA credentials",
+			"from", via[len(via)-1].URL.Host,
+			"to", req.URL.Host)
+		return nil
+	})
+}
+
+// sameOrigin tells whether u is on the host of base, with the same or a
+// more secure scheme.
+func sameOrigin(u *url.URL, base *url.URL) bool {
+	if !strings.EqualFold(u.Host, base.Host) {
+		return false
+	}
+	return u.Scheme == base.Scheme || u.Scheme == "https"
+}
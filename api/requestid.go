@@ -0,0 +1,63 @@
+package pvwaAPI
+
+import (
+	"crypto/rand"
+	"fmt"
+	"github.com/go-resty/resty/v2"
+	"log/slog"
+)
+
+// requestIDHeader carries a unique ID for every request so failures can be
+// matched with the corresponding entry in the appliance logs.
+const requestIDHeader = "X-Request-ID"
+
+// newRequestID returns a random (version 4) UUID.
+func newRequestID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(fmt.Sprintf("crypto/rand failed: %v", err))
+	}
+	b[6] = (b[6] & 0x0f) | 0x40 // version 4
+	b[8] = (b[8] & 0x3f) | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// useRequestIDs registers the middleware that adds a request ID to every
+// request made with c and logs it along with the outcome.
+func useRequestIDs(c *resty.Client) {
+	c.OnBeforeRequest(func(_ *resty.Client, r *resty.Request) error {
+		if r.Header.Get(requestIDHeader) == "" {
+			r.SetHeader(requestIDHeader, newRequestID())
+		}
+		return nil
+	})
+	c.OnAfterResponse(func(_ *resty.Client, resp *resty.Response) error {
+		slog.Debug("request completed",
+			"method", resp.Request.Method,
+			"url", resp.Request.URL,
+			"status", resp.StatusCode(),
+			"requestID", requestID(resp))
+		return nil
+	})
+	c.OnError(func(r *resty.Request, err error) {
+		slog.Error("request failed",
+			"method", r.Method,
+			"url", r.URL,
+			"requestID", r.Header.Get(requestIDHeader),
+			"error", err)
+	})
+}
+
+// requestID returns the request ID that was sent for resp.
+func requestID(resp *resty.Response) string {
+	if resp == nil || resp.Request == nil {
+		return ""
+	}
+	return resp.Request.Header.Get(requestIDHeader)
+}
+
+// statusError describes an unexpected response status, including the
+// request ID to look up on the appliance.
+func statusError(resp *resty.Response) error {
+	return fmt.Errorf("unexpected status code: %d (request ID %s)", resp.StatusCode(), requestID(resp))
+}
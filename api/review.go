@@ -0,0 +1,156 @@
+package pvwaAPI
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ReviewChange describes a recording file whose review state differs from
+// the one in an earlier export.
+type ReviewChange struct {
+	SessionID          string `json:"SessionID"`
+	SessionGuid        string `json:"SessionGuid"`
+	SafeName           string `json:"SafeName"`
+	FileName           string `json:"FileName"`
+	PreviousReviewBy   string `json:"PreviousReviewBy"`
+	PreviousReviewDate int64  `json:"PreviousReviewDate"`
+	LastReviewBy       string `json:"LastReviewBy"`
+	LastReviewDate     int64  `json:"LastReviewDate"`
+}
+
+// LoadRecordings reads the recording metadata written by an earlier export
+// below dir, both the per-session JSON files and recordings.ndjson. Other
+// JSON files of the export, like indexes and raw responses, are ignored.
+func LoadRecordings(dir string) (*SessionRecordings, error) {
+	loaded := &SessionRecordings{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		switch {
+		case strings.HasSuffix(path, ".ndjson"), strings.HasSuffix(path, ".json"):
+		default:
+			return nil
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		decoder := json.NewDecoder(f)
+		for {
+			var raw json.RawMessage
+			err := decoder.Decode(&raw)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("error reading %s: %w", path, err)
+			}
+			// Indexes and manifests are arrays or objects without a SessionID
+			if !bytes.HasPrefix(bytes.TrimSpace(raw), []byte("{")) {
+				return nil
+			}
+			var recording Recording
+			if err := json.Unmarshal(raw, &recording); err != nil {
+				return fmt.Errorf("error reading %s: %w", path, err)
+			}
+			if recording.SessionID == "" {
+				return nil
+			}
+			loaded.Recordings = append(loaded.Recordings, recording)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error loading earlier export: %w", err)
+	}
+	loaded.Total = len(loaded.Recordings)
+	return loaded, nil
+}
+
+// ReviewChanges compares the review state (LastReviewBy and LastReviewDate)
+// of every recording file with the one in previous and returns the files
+// that changed. Recordings are matched by SessionID and SessionGuid, the
+// ones missing from previous, or without RecordingFiles there, are not
+// reported.
+func (s *SessionRecordings) ReviewChanges(previous *SessionRecordings) []ReviewChange {
+	type fileKey struct{ sessionID, sessionGuid, fileName string }
+	known := make(map[fileKey]RecordingFile)
+	sessions := make(map[fileKey]bool)
+	for _, r := range previous.Recordings {
+		// Without the files of the earlier export nothing can be compared
+		if !r.Reported("RecordingFiles") {
+			continue
+		}
+		sessions[fileKey{r.SessionID, r.SessionGuid, ""}] = true
+		for _, f := range r.RecordingFiles {
+			known[fileKey{r.SessionID, r.SessionGuid, f.FileName}] = f
+		}
+	}
+
+	var changes []ReviewChange
+	for _, r := range s.Recordings {
+		if !sessions[fileKey{r.SessionID, r.SessionGuid, ""}] {
+			continue
+		}
+		for _, f := range r.RecordingFiles {
+			// A file new to the session counts as unreviewed before
+			before := known[fileKey{r.SessionID, r.SessionGuid, f.FileName}]
+			if before.LastReviewBy == f.LastReviewBy && before.LastReviewDate == f.LastReviewDate {
+				continue
+			}
+			changes = append(changes, ReviewChange{
+				SessionID:          r.SessionID,
+				SessionGuid:        r.SessionGuid,
+				SafeName:           r.SafeName,
+				FileName:           f.FileName,
+				PreviousReviewBy:   before.LastReviewBy,
+				PreviousReviewDate: before.LastReviewDate,
+				LastReviewBy:       f.LastReviewBy,
+				LastReviewDate:     f.LastReviewDate,
+			})
+		}
+	}
+	return changes
+}
+
+// SaveReviewChanges writes changes to review-changes.json in the specified
+// directory. The directory will be created if it doesn't exist.
+func SaveReviewChanges(dirname string, changes []ReviewChange) error {
+	if err := os.MkdirAll(dirname, DirMode); err != nil {
+		return fmt.Errorf("error creating directory: %w", err)
+	}
+	if changes == nil {
+		changes = []ReviewChange{}
+	}
+	data, err := json.MarshalIndent(changes, "", "    ")
+	if err != nil {
+		return fmt.Errorf("error marshaling review changes: %w", err)
+	}
+
+	filename, err := outputPath(filepath.Join(dirname, "review-changes.json"))
+	if errors.Is(err, ErrSkipped) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := writeFile(filename, data); err != nil {
+		return fmt.Errorf("error writing review changes: %w", err)
+	}
+	slog.Info("saved review changes", "file", filename, "changes", len(changes))
+	return nil
+}
@@ -0,0 +1,53 @@
+package pvwaAPI
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+)
+
+// TLSConfig is the TLS configuration of the clients created by
+// NewPVWAConfig. It defaults to TLS 1.2 as the minimum version and Go's
+// default cipher suites.
+var TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+
+// tlsVersions maps the accepted version names to their constants.
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ParseTLSVersion converts a version such as "1.2" to its crypto/tls
+// constant.
+func ParseTLSVersion(name string) (uint16, error) {
+	version, ok := tlsVersions[strings.TrimPrefix(strings.ToLower(name), "tls")]
+	if !ok {
+		return 0, fmt.Errorf("invalid TLS version %q, use '1.0', '1.1', '1.2' or '1.3'", name)
+	}
+	return version, nil
+}
+
+// ParseCipherSuites converts cipher suite names as used by crypto/tls (e.g.
+// TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384) to their IDs. Suites Go considers
+// insecure are accepted too, as a baseline may still require them.
+func ParseCipherSuites(names []string) ([]uint16, error) {
+	known := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+	for _, suite := range tls.InsecureCipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := known[strings.ToUpper(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
@@ -0,0 +1,173 @@
+package pvwaAPI
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-resty/resty/v2"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// defaultTokenSkewMargin is the TokenSkewMargin of new clients.
+const defaultTokenSkewMargin = time.Minute
+
+// AuthRetries is how many times NewPVWAConfig retries the initial logon
+// while PVWA can't be reached or answers with a server error, e.g. during
+// a restart of the appliance. Rejected credentials are never retried.
+var AuthRetries = 0
+
+// AuthRetryDelay is the wait before the first logon retry, doubled after
+// every further one.
+var AuthRetryDelay = 5 * time.Second
+
+// errLogonRejected is wrapped by GetAuthToken when PVWA answers the logon
+// with a client error, such as wrong credentials.
+var errLogonRejected = errors.New("logon rejected")
+
+// PasswordFile, when set, replaces PVWA_PASSWORD and the prompt as the
+// source of the password: a file with one password per line, tried in
+// order. It is read again whenever a password is rejected, so a password
+// rotated into it during the run is picked up.
+var PasswordFile string
+
+// readPasswordFile returns the non-empty lines of PasswordFile.
+func readPasswordFile() ([]string, error) {
+	data, err := os.ReadFile(PasswordFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading password file: %w", err)
+	}
+	var passwords []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimRight(line, "\r"); line != "" {
+			passwords = append(passwords, line)
+		}
+	}
+	if len(passwords) == 0 {
+		return nil, fmt.Errorf("password file %s holds no password", PasswordFile)
+	}
+	return passwords, nil
+}
+
+// logIn gets a new auth token with the current password. When PVWA rejects
+// it and a PasswordFile is used, the other passwords of the file are tried
+// in order, so a rotation during the run doesn't lock the export out.
+func (p *pvwaClient) logIn() error {
+	err := p.GetAuthToken(p.password)
+	if err == nil || PasswordFile == "" || !errors.Is(err, errLogonRejected) {
+		return err
+	}
+	passwords, readErr := readPasswordFile()
+	if readErr != nil {
+		return readErr
+	}
+	rejected := p.password
+	for i, password := range passwords {
+		if password == rejected {
+			continue
+		}
+		slog.Warn("password rejected, trying the next one from the password file",
+			"username", p.Username,
+			"line", i+1)
+		err = p.GetAuthToken(password)
+		if err == nil || !errors.Is(err, errLogonRejected) {
+			return err
+		}
+	}
+	return err
+}
+
+// logon gets the first auth token of a new client, retrying as configured
+// by AuthRetries and AuthRetryDelay.
+func (p *pvwaClient) logon() error {
+	delay := AuthRetryDelay
+	for attempt := 0; ; attempt++ {
+		err := p.logIn()
+		if err == nil || errors.Is(err, errLogonRejected) || attempt >= AuthRetries {
+			return err
+		}
+		slog.Warn("could not log in to PVWA, retrying",
+			"retry", attempt+1,
+			"retries", AuthRetries,
+			"delay", delay,
+			"error", err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// validToken returns the auth token to use for the next request. When a
+// TokenLifetime is configured and the token is within TokenSkewMargin of
+// expiring, the client logs in again with the credentials it was created
+// with first. It is safe for concurrent use by the download workers.
+func (p *pvwaClient) validToken() (string, error) {
+	p.tokenMu.Lock()
+	defer p.tokenMu.Unlock()
+
+	if p.TokenLifetime <= 0 || p.password == "" {
+		return p.AuthToken, nil
+	}
+
+	age := time.Since(p.tokenIssued)
+	if age < p.TokenLifetime-p.TokenSkewMargin {
+		return p.AuthToken, nil
+	}
+
+	slog.Debug("auth token nearing expiry, renewing",
+		"age", age.Round(time.Second),
+		"lifetime", p.TokenLifetime,
+		"margin", p.TokenSkewMargin)
+	if err := p.logIn(); err != nil {
+		return "", fmt.Errorf("could not renew the authorization token: %w", err)
+	}
+	slog.Info("renewed auth token", "username", p.Username)
+	return p.AuthToken, nil
+}
+
+// renewRejectedToken logs in again after the appliance rejected token and
+// returns the new one. When another worker already replaced the rejected
+// token its replacement is returned instead of logging in twice.
+func (p *pvwaClient) renewRejectedToken(token string) (string, error) {
+	p.tokenMu.Lock()
+	defer p.tokenMu.Unlock()
+
+	if p.AuthToken != token {
+		return p.AuthToken, nil
+	}
+	slog.Warn("auth token rejected by the appliance, logging in again", "username", p.Username)
+	if err := p.logIn(); err != nil {
+		return "", fmt.Errorf("could not renew the rejected authorization token: %w", err)
+	}
+	return p.AuthToken, nil
+}
+
+// authorized sends the request built by send with a valid auth token. The
+// appliance is the authority on whether a token has expired: when it answers
+// 401 Unauthorized the client logs in again once and repeats the request,
+// whatever the local clock says about the token's age.
+func (p *pvwaClient) authorized(send func(token string) (*resty.Response, error)) (*resty.Response, error) {
+	token, err := p.validToken()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := send(token)
+	if err != nil || resp.StatusCode() != http.StatusUnauthorized || p.password == "" {
+		return resp, err
+	}
+
+	// Release the connection of a streamed response before retrying
+	if body := resp.RawBody(); body != nil {
+		io.Copy(io.Discard, io.LimitReader(body, maxDrainBytes))
+		body.Close()
+	}
+
+	token, err = p.renewRejectedToken(token)
+	if err != nil {
+		return nil, err
+	}
+	return send(token)
+}
@@ -0,0 +1,66 @@
+package pvwaAPI
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// UserAgent is the User-Agent header of every request made by the clients
+// created by NewPVWAConfig, so the appliance's logs and WAF can tell the
+// export apart from other tools.
+var UserAgent = "export-cyberark-recordings"
+
+// HTTPVersion selects the HTTP version of the clients created by
+// NewPVWAConfig. "2" negotiates HTTP/2 with the appliance (or its load
+// balancer) over TLS and falls back to HTTP/1.1 when it isn't offered,
+// "1.1" never uses HTTP/2.
+var HTTPVersion = "2"
+
+// IdleConnTimeout is how long an idle keep-alive connection to PVWA is
+// kept open for the next request.
+var IdleConnTimeout = 90 * time.Second
+
+// KeepAlive is the interval of the TCP keep-alive probes on connections to
+// PVWA. A negative value disables them.
+var KeepAlive = 30 * time.Second
+
+// MaxIdleConnsPerHost is how many idle connections to PVWA are kept open.
+// HTTP/1.1 needs a connection per concurrent request, so it should be at
+// least the number of workers; HTTP/2 multiplexes them on one connection.
+var MaxIdleConnsPerHost = 2
+
+// ParseHTTPVersion validates an HTTP version name for HTTPVersion.
+func ParseHTTPVersion(name string) (string, error) {
+	switch name {
+	case "2", "1.1":
+		return name, nil
+	}
+	return "", fmt.Errorf("invalid HTTP version %q, use '2' or '1.1'", name)
+}
+
+// newTransport creates the transport of a new client from HTTPVersion and
+// the keep-alive settings. TLSConfig is applied by the caller.
+func newTransport() *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: KeepAlive,
+	}
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     HTTPVersion == "2",
+		MaxIdleConns:          max(MaxIdleConnsPerHost, 100),
+		MaxIdleConnsPerHost:   MaxIdleConnsPerHost,
+		IdleConnTimeout:       IdleConnTimeout,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+	if HTTPVersion == "1.1" {
+		// A non-nil, empty map keeps the transport from offering HTTP/2
+		transport.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+	return transport
+}
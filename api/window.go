@@ -0,0 +1,142 @@
+package pvwaAPI
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrDeadlineReached is the error of the downloads DownloadRecordings
+// didn't start because the client's Deadline had passed.
+var ErrDeadlineReached = errors.New("deadline reached")
+
+// ActiveWindow is a daily time window, such as 22:00-06:00, outside of
+// which DownloadRecordings doesn't start new downloads. A window whose end
+// is before its start runs over midnight.
+type ActiveWindow struct {
+	// start and end are minutes after midnight
+	start, end int
+	location   *time.Location
+}
+
+// ParseActiveWindow parses a window given as "HH:MM-HH:MM", optionally
+// followed by a time zone name, e.g. "22:00-06:00 Europe/Berlin". Without
+// a time zone the local time is used.
+func ParseActiveWindow(value string) (*ActiveWindow, error) {
+	span, zone, _ := strings.Cut(strings.TrimSpace(value), " ")
+	from, to, ok := strings.Cut(span, "-")
+	if !ok {
+		return nil, fmt.Errorf("invalid active window %q, use 'HH:MM-HH:MM' with an optional time zone (e.g. '22:00-06:00 Europe/Berlin')", value)
+	}
+	start, err := parseClock(from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid active window %q: %w", value, err)
+	}
+	end, err := parseClock(to)
+	if err != nil {
+		return nil, fmt.Errorf("invalid active window %q: %w", value, err)
+	}
+	if start == end {
+		return nil, fmt.Errorf("invalid active window %q: start and end are the same", value)
+	}
+
+	location := time.Local
+	if zone = strings.TrimSpace(zone); zone != "" {
+		location, err = time.LoadLocation(zone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid active window time zone: %w", err)
+		}
+	}
+	return &ActiveWindow{start: start, end: end, location: location}, nil
+}
+
+// parseClock parses a time of day as HH:MM into minutes after midnight.
+func parseClock(value string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q, use HH:MM", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func (w *ActiveWindow) String() string {
+	return fmt.Sprintf("%02d:%02d-%02d:%02d %s", w.start/60, w.start%60, w.end/60, w.end%60, w.location)
+}
+
+// untilOpen returns how long after now the window opens next, or zero when
+// now is inside the window.
+func (w *ActiveWindow) untilOpen(now time.Time) time.Duration {
+	t := now.In(w.location)
+	clock := t.Hour()*60 + t.Minute()
+	inside := clock >= w.start && clock < w.end
+	if w.end < w.start {
+		inside = clock >= w.start || clock < w.end
+	}
+	if inside {
+		return 0
+	}
+
+	opens := time.Date(t.Year(), t.Month(), t.Day(), w.start/60, w.start%60, 0, 0, w.location)
+	if !opens.After(t) {
+		opens = time.Date(t.Year(), t.Month(), t.Day()+1, w.start/60, w.start%60, 0, 0, w.location)
+	}
+	return opens.Sub(t)
+}
+
+// waitForActiveWindow blocks while the time is outside the client's
+// ActiveWindow. It returns early when done is closed. The window is checked
+// again after every wait, so clock changes don't cut the pause short.
+func (p *pvwaClient) waitForActiveWindow(done <-chan struct{}) {
+	if p.ActiveWindow == nil {
+		return
+	}
+	paused := false
+	for {
+		wait := p.ActiveWindow.untilOpen(time.Now())
+		if wait <= 0 {
+			break
+		}
+		if !paused {
+			slog.Info("outside the active window, pausing downloads",
+				"window", p.ActiveWindow,
+				"resume", time.Now().Add(wait).Round(time.Second))
+			paused = true
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-done:
+			timer.Stop()
+			return
+		}
+	}
+	if paused {
+		slog.Info("active window open, resuming downloads", "window", p.ActiveWindow)
+	}
+}
+
+// stopAtDeadline returns a channel that is closed when done is closed or
+// the client's Deadline passes, whichever comes first, and a function that
+// releases the timer once the channel isn't needed anymore. Without a
+// Deadline done itself is returned.
+func (p *pvwaClient) stopAtDeadline(done <-chan struct{}) (<-chan struct{}, func()) {
+	if p.Deadline.IsZero() {
+		return done, func() {}
+	}
+	stop := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(stop)
+		timer := time.NewTimer(time.Until(p.Deadline))
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-done:
+		case <-finished:
+		}
+	}()
+	return stop, sync.OnceFunc(func() { close(finished) })
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"export-recordings/api"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// archiveDirectory packs the contents of dir into a single archive placed
+// next to it (e.g. downloaded_recordings/2024-05 ->
+// downloaded_recordings/2024-05.tar.gz). Entries are stored under the
+// directory's base name so the session filenames are preserved when
+// extracted. Supported formats are "tar.gz" and "zip". An existing archive
+// is handled according to the overwrite policy; when it is skipped the
+// error is pvwaAPI.ErrSkipped and dir is kept. When removeSource is true
+// the directory is deleted once the archive has been written successfully.
+func archiveDirectory(dir string, format string, removeSource bool) (string, error) {
+	dir = filepath.Clean(dir)
+	archivePath, err := pvwaAPI.OutputPath(dir + "." + format)
+	if err != nil {
+		return "", err
+	}
+
+	out, err := pvwaAPI.CreateFile(archivePath)
+	if err != nil {
+		return "", fmt.Errorf("error creating archive: %w", err)
+	}
+
+	switch format {
+	case "tar.gz":
+		err = writeTarGz(out, dir)
+	case "zip":
+		err = writeZip(out, dir)
+	default:
+		err = fmt.Errorf("unsupported archive format %q", format)
+	}
+
+	if closeErr := out.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("error closing archive: %w", closeErr)
+	}
+	if err != nil {
+		os.Remove(archivePath)
+		return "", err
+	}
+
+	pvwaAPI.RecordWritten(archivePath)
+
+	if removeSource {
+		if err := os.RemoveAll(dir); err != nil {
+			return archivePath, fmt.Errorf("error removing archived directory: %w", err)
+		}
+	}
+
+	return archivePath, nil
+}
+
+// walkArchiveFiles calls fn for every regular file below dir with the
+// name it should have inside the archive.
+func walkArchiveFiles(dir string, fn func(path string, name string, info os.FileInfo) error) error {
+	parent := filepath.Dir(dir)
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		name, err := filepath.Rel(parent, path)
+		if err != nil {
+			return err
+		}
+		return fn(path, filepath.ToSlash(name), info)
+	})
+}
+
+func writeTarGz(w io.Writer, dir string) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	err := walkArchiveFiles(dir, func(path string, name string, info os.FileInfo) error {
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return fmt.Errorf("error creating tar header for %s: %w", path, err)
+		}
+		header.Name = name
+		if err := tw.WriteHeader(header); err != nil {
+			return fmt.Errorf("error writing tar header for %s: %w", path, err)
+		}
+		return copyFileTo(tw, path)
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("error finalizing tar: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("error finalizing gzip: %w", err)
+	}
+	return nil
+}
+
+func writeZip(w io.Writer, dir string) error {
+	zw := zip.NewWriter(w)
+
+	err := walkArchiveFiles(dir, func(path string, name string, info os.FileInfo) error {
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return fmt.Errorf("error creating zip header for %s: %w", path, err)
+		}
+		header.Name = name
+		header.Method = zip.Deflate
+		entry, err := zw.CreateHeader(header)
+		if err != nil {
+			return fmt.Errorf("error writing zip header for %s: %w", path, err)
+		}
+		return copyFileTo(entry, path)
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("error finalizing zip: %w", err)
+	}
+	return nil
+}
+
+func copyFileTo(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("error opening %s: %w", path, err)
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(w, f); err != nil {
+		return fmt.Errorf("error archiving %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"export-recordings/api"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// auditLogName is the name of the append-only audit log written to the
+// root of the output directory.
+const auditLogName = "export-audit.log"
+
+// auditEvent is one line of the export audit log. Every run writes a
+// "started" event before anything is retrieved and a "completed" event
+// once all periods have been exported, an "interrupted" one when it is
+// stopped by a signal or a "failed" one when it ends with an error, all
+// sharing the same RunID.
+type auditEvent struct {
+	RunID    string    `json:"run_id"`
+	Event    string    `json:"event"`
+	Time     time.Time `json:"time"`
+	Username string    `json:"username"`
+	BaseURL  string    `json:"base_url"`
+	// Parameters holds the command line flags that were explicitly set
+	Parameters map[string]string `json:"parameters"`
+	// Periods lists what was exported, only set on the closing event
+	Periods []auditPeriod `json:"periods,omitempty"`
+	// Recordings is the number of recordings exported, zero on "started"
+	Recordings int `json:"recordings"`
+	// Error is the error a "failed" run ended with
+	Error string `json:"error,omitempty"`
+}
+
+// auditPeriod records where the recordings of one period were written.
+type auditPeriod struct {
+	Name       string `json:"name"`
+	Recordings int    `json:"recordings"`
+	OutputPath string `json:"output_path"`
+	// MetadataPath is only set when the metadata went to another directory
+	MetadataPath string `json:"metadata_path,omitempty"`
+}
+
+// newRunID returns a random identifier used to correlate audit events.
+func newRunID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// explicitFlags returns the flags of fs that were set on the command line,
+// with redactedFlags redacted.
+func explicitFlags(fs *flag.FlagSet) map[string]string {
+	params := make(map[string]string)
+	fs.Visit(func(f *flag.Flag) {
+		params[f.Name] = flagValue(f)
+	})
+	return params
+}
+
+// appendAuditEvent appends event as a JSON line to the audit log in dir.
+// The file is only ever opened for appending so earlier entries are never
+// rewritten.
+func appendAuditEvent(dir string, event auditEvent) error {
+	if err := os.MkdirAll(dir, pvwaAPI.DirMode); err != nil {
+		return fmt.Errorf("error creating audit log directory: %w", err)
+	}
+
+	line, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("error marshaling audit event: %w", err)
+	}
+
+	f, err := os.OpenFile(filepath.Join(dir, auditLogName), os.O_APPEND|os.O_CREATE|os.O_WRONLY, pvwaAPI.FileMode)
+	if err != nil {
+		return fmt.Errorf("error opening audit log: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("error writing audit log: %w", err)
+	}
+	return nil
+}
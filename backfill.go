@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"export-recordings/api"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+)
+
+// checkpointName is the name of the backfill checkpoint written to the
+// root of the output directory.
+const checkpointName = "backfill-checkpoint.json"
+
+// checkpoint records the progress of a backfill so an interrupted run can
+// resume where it left off. Periods are completed once everything in them
+// was exported, until then the downloaded sessions are tracked one by one.
+type checkpoint struct {
+	mu   sync.Mutex
+	path string
+	// CompletedPeriods are skipped entirely on restart
+	CompletedPeriods []string `json:"completed_periods"`
+	// Sessions holds the downloaded SessionIDs of periods in progress
+	Sessions map[string][]string `json:"sessions"`
+}
+
+// loadCheckpoint reads the checkpoint from dir, or returns an empty one if
+// no backfill was started there yet.
+func loadCheckpoint(dir string) (*checkpoint, error) {
+	c := &checkpoint{
+		path:     filepath.Join(dir, checkpointName),
+		Sessions: make(map[string][]string),
+	}
+	data, err := os.ReadFile(c.path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading checkpoint: %w", err)
+	}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("error parsing checkpoint %s: %w", c.path, err)
+	}
+	if c.Sessions == nil {
+		c.Sessions = make(map[string][]string)
+	}
+	// Months used to be named by their number only, without the year the
+	// checkpoint was written for
+	periods := slices.Clone(c.CompletedPeriods)
+	for period := range c.Sessions {
+		periods = append(periods, period)
+	}
+	for _, period := range periods {
+		if _, err := strconv.Atoi(period); err == nil {
+			return nil, fmt.Errorf("checkpoint %s names month %s without its year, it was written by an earlier version; delete it to start over", c.path, period)
+		}
+	}
+	return c, nil
+}
+
+// periodDone reports whether period was completed by an earlier run.
+func (c *checkpoint) periodDone(period string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Contains(c.CompletedPeriods, period)
+}
+
+// pending returns the recordings of period that have not been downloaded
+// yet.
+func (c *checkpoint) pending(period string, sessions *pvwaAPI.SessionRecordings) *pvwaAPI.SessionRecordings {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	done := c.Sessions[period]
+	return sessions.Filter(func(r pvwaAPI.Recording) bool {
+		return !slices.Contains(done, r.SessionID)
+	})
+}
+
+// sessionDone records that the recording sessionID of period has been
+// downloaded and saves the checkpoint.
+func (c *checkpoint) sessionDone(period string, sessionID string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Sessions[period] = append(c.Sessions[period], sessionID)
+	return c.save()
+}
+
+// periodCompleted marks period as completed and saves the checkpoint.
+func (c *checkpoint) periodCompleted(period string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.CompletedPeriods = append(c.CompletedPeriods, period)
+	delete(c.Sessions, period)
+	return c.save()
+}
+
+// save writes the checkpoint to a temporary file that replaces the old one,
+// so an interruption never leaves a truncated checkpoint behind. The
+// caller must hold c.mu.
+func (c *checkpoint) save() error {
+	data, err := json.MarshalIndent(c, "", "    ")
+	if err != nil {
+		return fmt.Errorf("error marshaling checkpoint: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), pvwaAPI.DirMode); err != nil {
+		return fmt.Errorf("error creating checkpoint directory: %w", err)
+	}
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, data, pvwaAPI.FileMode); err != nil {
+		return fmt.Errorf("error writing checkpoint: %w", err)
+	}
+	if err := os.Rename(tmp, c.path); err != nil {
+		return fmt.Errorf("error writing checkpoint: %w", err)
+	}
+	return nil
+}
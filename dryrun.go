@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+)
+
+// validateBaseURL checks that the -baseURL flag is an absolute http(s) URL.
+func validateBaseURL(baseURL string) error {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return fmt.Errorf("invalid -baseURL %q: %w", baseURL, err)
+	}
+	if (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return fmt.Errorf("invalid -baseURL %q, expected an absolute URL like https://pvwa.example.com/PasswordVault/API", baseURL)
+	}
+	return nil
+}
+
+// printEffectiveConfig writes the value of every flag of fs, set or
+// default, to w as JSON, together with where the password would come from.
+// The password itself is never printed, and redactedFlags are redacted.
+func printEffectiveConfig(w io.Writer, fs *flag.FlagSet) error {
+	config := struct {
+		Flags    map[string]string `json:"flags"`
+		Explicit []string          `json:"explicit"`
+		Password string            `json:"password"`
+	}{
+		Flags:    make(map[string]string),
+		Explicit: []string{},
+		Password: "prompt",
+	}
+	fs.VisitAll(func(f *flag.Flag) {
+		config.Flags[f.Name] = flagValue(f)
+	})
+	fs.Visit(func(f *flag.Flag) {
+		config.Explicit = append(config.Explicit, f.Name)
+	})
+	if file := fs.Lookup("password-file"); file != nil && file.Value.String() != "" {
+		config.Password = "password file " + file.Value.String()
+	} else if os.Getenv("PVWA_PASSWORD") != "" {
+		config.Password = "PVWA_PASSWORD (redacted)"
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "    ")
+	if err := encoder.Encode(config); err != nil {
+		return fmt.Errorf("error writing configuration: %w", err)
+	}
+	return nil
+}
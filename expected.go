@@ -0,0 +1,302 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"export-recordings/api"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Reconciliation statuses of an expected or exported session.
+const (
+	statusPresent    = "present"
+	statusMissing    = "missing"
+	statusUnexpected = "unexpected"
+)
+
+// csvDialect is the format of the CSV files the run reads and writes.
+type csvDialect struct {
+	// delimiter separates the fields, ',' by default
+	delimiter rune
+	// bom starts written files with a UTF-8 byte order mark, which
+	// spreadsheets need to recognize the encoding
+	bom bool
+}
+
+// parseCSVDelimiter parses the field delimiter given as a single
+// character, or as "tab".
+func parseCSVDelimiter(value string) (rune, error) {
+	if value == "tab" {
+		return '\t', nil
+	}
+	delimiter, size := utf8.DecodeRuneInString(value)
+	if size == 0 || size != len(value) || delimiter == utf8.RuneError ||
+		delimiter == '"' || delimiter == '\r' || delimiter == '\n' {
+		return 0, fmt.Errorf("invalid CSV delimiter %q, use a single character such as ',' or ';', or 'tab'", value)
+	}
+	return delimiter, nil
+}
+
+// expectedStartLayouts are the formats accepted in the Start column of an
+// expected sessions file, besides Unix timestamps.
+var expectedStartLayouts = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"}
+
+// expectedSession is one row of an expected sessions file. Start is zero
+// when the file has no Start column or the cell is empty.
+type expectedSession struct {
+	SessionID string
+	Start     time.Time
+}
+
+// readExpectedSessions reads a CSV file listing the sessions that should
+// be exported. The header row names the columns: SessionID is required,
+// Start is optional and assigns sessions that weren't found to the period
+// they should have been in. Column names are matched case-insensitively.
+// Fields are separated by the dialect's delimiter.
+func readExpectedSessions(path string, dialect csvDialect) ([]expectedSession, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening expected sessions file: %w", err)
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.Comma = dialect.delimiter
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("error reading expected sessions header: %w", err)
+	}
+	idColumn, startColumn := -1, -1
+	for i, name := range header {
+		// Spreadsheets often save CSV with a byte order mark
+		name = strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))
+		switch {
+		case strings.EqualFold(name, "SessionID"):
+			idColumn = i
+		case strings.EqualFold(name, "Start"):
+			startColumn = i
+		}
+	}
+	if idColumn < 0 {
+		return nil, fmt.Errorf("expected sessions file %s has no SessionID column", path)
+	}
+
+	var sessions []expectedSession
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading expected sessions: %w", err)
+		}
+		line, _ := reader.FieldPos(0)
+		if idColumn >= len(record) || strings.TrimSpace(record[idColumn]) == "" {
+			continue
+		}
+		session := expectedSession{SessionID: strings.TrimSpace(record[idColumn])}
+		if startColumn >= 0 && startColumn < len(record) && strings.TrimSpace(record[startColumn]) != "" {
+			session.Start, err = parseExpectedStart(strings.TrimSpace(record[startColumn]))
+			if err != nil {
+				return nil, fmt.Errorf("line %d of %s: %w", line, path, err)
+			}
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, nil
+}
+
+// parseExpectedStart parses a Start cell, either a Unix timestamp or one
+// of expectedStartLayouts in UTC.
+func parseExpectedStart(value string) (time.Time, error) {
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	for _, layout := range expectedStartLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid start %q, use a Unix timestamp, YYYY-MM-DD or RFC 3339", value)
+}
+
+// reconciliationRow is one line of the reconciliation report. Reason
+// explains why a session is missing.
+type reconciliationRow struct {
+	Period    string
+	SessionID string
+	Status    string
+	Reason    string
+}
+
+// sessionReconciliation compares the exported sessions of every period
+// with the expected sessions.
+type sessionReconciliation struct {
+	expected []expectedSession
+	byID     map[string]bool
+	// found holds the expected sessions already reported for a period
+	found map[string]bool
+	rows  []reconciliationRow
+}
+
+func newSessionReconciliation(expected []expectedSession) *sessionReconciliation {
+	byID := make(map[string]bool, len(expected))
+	for _, session := range expected {
+		byID[session.SessionID] = true
+	}
+	return &sessionReconciliation{
+		expected: expected,
+		byID:     byID,
+		found:    make(map[string]bool),
+	}
+}
+
+// addPeriod reconciles one period. retrieved holds the recordings PVWA
+// returned before filtering, selected the ones chosen for download and
+// failures the downloads that failed. A session counts as present when it
+// was selected and didn't fail. Expected sessions PVWA didn't return are
+// missing from the period their Start falls in.
+func (s *sessionReconciliation) addPeriod(period exportPeriod, retrieved, selected *pvwaAPI.SessionRecordings, failures []pvwaAPI.DownloadFailure) {
+	isSelected := make(map[string]bool, len(selected.Recordings))
+	for _, r := range selected.Recordings {
+		isSelected[r.SessionID] = true
+	}
+	isFailed := make(map[string]bool, len(failures))
+	notStarted := make(map[string]bool)
+	for _, f := range failures {
+		isFailed[f.SessionID] = true
+		notStarted[f.SessionID] = errors.Is(f.Err, pvwaAPI.ErrDeadlineReached)
+	}
+
+	var present, missing, unexpected int
+	for _, r := range retrieved.Recordings {
+		row := reconciliationRow{Period: period.name, SessionID: r.SessionID}
+		exported := isSelected[r.SessionID] && !isFailed[r.SessionID]
+		switch {
+		case !s.byID[r.SessionID]:
+			if !exported {
+				continue
+			}
+			row.Status = statusUnexpected
+			unexpected++
+		case s.found[r.SessionID]:
+			continue
+		case exported:
+			row.Status = statusPresent
+			present++
+		case notStarted[r.SessionID]:
+			row.Status, row.Reason = statusMissing, "deadline reached"
+			missing++
+		case isFailed[r.SessionID]:
+			row.Status, row.Reason = statusMissing, "download failed"
+			missing++
+		default:
+			row.Status, row.Reason = statusMissing, "not selected"
+			missing++
+		}
+		if row.Status != statusUnexpected {
+			s.found[r.SessionID] = true
+		}
+		s.rows = append(s.rows, row)
+	}
+	if !period.from.IsZero() {
+		for _, session := range s.expected {
+			if s.found[session.SessionID] || session.Start.IsZero() ||
+				session.Start.Before(period.from) || session.Start.After(period.to) {
+				continue
+			}
+			s.found[session.SessionID] = true
+			s.rows = append(s.rows, reconciliationRow{
+				Period:    period.name,
+				SessionID: session.SessionID,
+				Status:    statusMissing,
+				Reason:    "not found",
+			})
+			missing++
+		}
+	}
+
+	logf := slog.Info
+	if missing > 0 || unexpected > 0 {
+		logf = slog.Warn
+	}
+	logf("reconciled expected sessions",
+		"period", period.name,
+		"present", present,
+		"missing", missing,
+		"unexpected", unexpected)
+}
+
+// finish reports the expected sessions that no period accounted for as
+// missing without a period, and returns all rows.
+func (s *sessionReconciliation) finish() []reconciliationRow {
+	var missing int
+	for _, session := range s.expected {
+		if s.found[session.SessionID] {
+			continue
+		}
+		s.found[session.SessionID] = true
+		s.rows = append(s.rows, reconciliationRow{
+			SessionID: session.SessionID,
+			Status:    statusMissing,
+			Reason:    "not found",
+		})
+		missing++
+	}
+	if missing > 0 {
+		slog.Warn("expected sessions not found in any period", "missing", missing)
+	}
+	return s.rows
+}
+
+// writeReconciliationReport writes rows as CSV to path. In WORM mode,
+// where an earlier report can't be replaced, the run ID is added to the
+// name.
+func writeReconciliationReport(path string, runID string, rows []reconciliationRow, dialect csvDialect) error {
+	if pvwaAPI.WORM {
+		ext := filepath.Ext(path)
+		path = strings.TrimSuffix(path, ext) + "-" + runID + ext
+	}
+	if err := os.MkdirAll(filepath.Dir(path), pvwaAPI.DirMode); err != nil {
+		return fmt.Errorf("error creating reconciliation report directory: %w", err)
+	}
+	out, err := pvwaAPI.CreateFile(path)
+	if err != nil {
+		return fmt.Errorf("error creating reconciliation report: %w", err)
+	}
+	defer out.Close()
+
+	if dialect.bom {
+		if _, err := out.WriteString("\ufeff"); err != nil {
+			return fmt.Errorf("error writing reconciliation report: %w", err)
+		}
+	}
+	// csv.Writer quotes fields holding the delimiter, quotes or line
+	// breaks as RFC 4180 describes
+	writer := csv.NewWriter(out)
+	writer.Comma = dialect.delimiter
+	writer.Write([]string{"Period", "SessionID", "Status", "Reason"})
+	for _, row := range rows {
+		writer.Write([]string{row.Period, row.SessionID, row.Status, row.Reason})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return fmt.Errorf("error writing reconciliation report: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("error writing reconciliation report: %w", err)
+	}
+	pvwaAPI.RecordWritten(path)
+	slog.Info("saved reconciliation report", "file", path, "rows", len(rows))
+	return nil
+}
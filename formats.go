@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"export-recordings/api"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// sessionFormats lists the recording files a session offers.
+type sessionFormats struct {
+	Period    string                  `json:"period"`
+	SessionID string                  `json:"session_id"`
+	Start     time.Time               `json:"start"`
+	Files     []pvwaAPI.RecordingFile `json:"files"`
+	// FilesReported is false when the appliance didn't return
+	// RecordingFiles at all, as opposed to an empty list
+	FilesReported bool `json:"files_reported"`
+}
+
+// formatListing collects the recording files of every session for
+// -list-formats.
+type formatListing struct {
+	sessions []sessionFormats
+}
+
+// add lists the sessions of one period.
+func (l *formatListing) add(period string, sessions *pvwaAPI.SessionRecordings) {
+	for _, r := range sessions.Recordings {
+		l.sessions = append(l.sessions, sessionFormats{
+			Period:        period,
+			SessionID:     r.SessionID,
+			Start:         time.Unix(r.Start, 0).UTC(),
+			Files:         r.RecordingFiles,
+			FilesReported: r.Reported("RecordingFiles"),
+		})
+	}
+}
+
+// writeJSON writes one JSON object per session to w.
+func (l *formatListing) writeJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	for _, session := range l.sessions {
+		if session.Files == nil {
+			session.Files = []pvwaAPI.RecordingFile{}
+		}
+		if err := encoder.Encode(session); err != nil {
+			return fmt.Errorf("error writing recording formats: %w", err)
+		}
+	}
+	return nil
+}
+
+// writeTable writes every recording file of every session to w, followed
+// by the number of files per type and format and the sessions without any
+// file.
+func (l *formatListing) writeTable(w io.Writer) {
+	type formatKey struct {
+		recordingType int
+		format        string
+	}
+	type formatTotal struct {
+		files int
+		size  int64
+	}
+	totals := make(map[formatKey]formatTotal)
+	withoutFiles := 0
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PERIOD\tSESSION\tSTART\tFILE\tTYPE\tFORMAT\tSIZE\tCOMPRESSED")
+	for _, s := range l.sessions {
+		start := s.Start.Format("2006-01-02 15:04")
+		if len(s.Files) == 0 {
+			withoutFiles++
+			note := "(no files)"
+			if !s.FilesReported {
+				note = "(not reported)"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t-\t-\t-\t-\n", s.Period, s.SessionID, start, note)
+			continue
+		}
+		for _, f := range s.Files {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%s\t%s\t%s\n",
+				s.Period, s.SessionID, start, f.FileName, f.RecordingType, f.Format,
+				pvwaAPI.FormatBytes(float64(f.FileSize)), pvwaAPI.FormatBytes(float64(f.CompressedFileSize)))
+			key := formatKey{f.RecordingType, f.Format}
+			total := totals[key]
+			total.files++
+			total.size += f.FileSize
+			totals[key] = total
+		}
+	}
+	tw.Flush()
+
+	keys := make([]formatKey, 0, len(totals))
+	for key := range totals {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].recordingType != keys[j].recordingType {
+			return keys[i].recordingType < keys[j].recordingType
+		}
+		return keys[i].format < keys[j].format
+	})
+	fmt.Fprintln(w)
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TYPE\tFORMAT\tFILES\tSIZE")
+	for _, key := range keys {
+		fmt.Fprintf(tw, "%d\t%s\t%d\t%s\n", key.recordingType, key.format, totals[key].files, pvwaAPI.FormatBytes(float64(totals[key].size)))
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "%d sessions, %d without recording files\n", len(l.sessions), withoutFiles)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"export-recordings/api"
+	"fmt"
+	"golang.org/x/term"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// selectionPageSize is the number of recordings listed per page.
+const selectionPageSize = 20
+
+// canSelectInteractively reports whether an operator can answer prompts,
+// which needs both stdin and stdout to be terminals.
+func canSelectInteractively() bool {
+	return term.IsTerminal(int(os.Stdin.Fd())) && term.IsTerminal(int(os.Stdout.Fd()))
+}
+
+// selectRecordings lists the recordings of sessions page by page on out
+// and lets the operator pick the ones to download by reading commands from
+// in. It returns the selected recordings in their original order.
+func selectRecordings(period string, sessions *pvwaAPI.SessionRecordings, in io.Reader, out io.Writer) (*pvwaAPI.SessionRecordings, error) {
+	selected := make(map[int]bool)
+	recordings := sessions.Recordings
+	page := 0
+	pages := (len(recordings) + selectionPageSize - 1) / selectionPageSize
+	scanner := bufio.NewScanner(in)
+
+	for {
+		printSelectionPage(out, period, recordings, selected, page, pages)
+		fmt.Fprint(out, "select> ")
+		if !scanner.Scan() {
+			if err := scanner.Err(); err != nil {
+				return nil, fmt.Errorf("error reading selection: %w", err)
+			}
+			return nil, errors.New("selection aborted")
+		}
+
+		command := strings.TrimSpace(scanner.Text())
+		switch command {
+		case "n", "":
+			if page < pages-1 {
+				page++
+			}
+		case "p":
+			if page > 0 {
+				page--
+			}
+		case "a":
+			for i := range recordings {
+				selected[i] = true
+			}
+		case "c":
+			clear(selected)
+		case "d":
+			// Filter visits the recordings in order
+			i := -1
+			return sessions.Filter(func(pvwaAPI.Recording) bool {
+				i++
+				return selected[i]
+			}), nil
+		case "q":
+			return sessions.Filter(func(pvwaAPI.Recording) bool { return false }), nil
+		default:
+			indexes, err := parseSelection(command, len(recordings))
+			if err != nil {
+				fmt.Fprintln(out, err)
+				continue
+			}
+			for _, i := range indexes {
+				if selected[i] {
+					delete(selected, i)
+				} else {
+					selected[i] = true
+				}
+			}
+		}
+	}
+}
+
+// printSelectionPage writes one page of the recording list to out.
+func printSelectionPage(out io.Writer, period string, recordings []pvwaAPI.Recording, selected map[int]bool, page int, pages int) {
+	fmt.Fprintf(out, "\nPeriod %s, page %d/%d, %d of %d recordings selected\n", period, page+1, max(pages, 1), len(selected), len(recordings))
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "\t#\tSTART\tUSER\tMACHINE\tDURATION\tRISK\tSEVERITY")
+	start := page * selectionPageSize
+	for i := start; i < min(start+selectionPageSize, len(recordings)); i++ {
+		r := recordings[i]
+		mark := " "
+		if selected[i] {
+			mark = "*"
+		}
+		risk := "-"
+		if r.Reported("RiskScore") {
+			risk = strconv.FormatFloat(r.RiskScore, 'f', 1, 64)
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			mark, i+1,
+			time.Unix(r.Start, 0).UTC().Format("2006-01-02 15:04"),
+			r.User, r.RemoteMachine,
+			r.DurationHuman(),
+			risk, r.Severity)
+	}
+	tw.Flush()
+	fmt.Fprintln(out, "Toggle with numbers or ranges (1,3,5-7); n/p next/previous page, a all, c clear, d download selected, q skip period")
+}
+
+// parseSelection parses a list of 1-based numbers and ranges such as
+// "1,3,5-7" into 0-based indexes below count.
+func parseSelection(value string, count int) ([]int, error) {
+	var indexes []int
+	for _, part := range splitList(value) {
+		first, last, isRange := strings.Cut(part, "-")
+		if !isRange {
+			last = first
+		}
+		start, err := strconv.Atoi(strings.TrimSpace(first))
+		if err != nil {
+			return nil, fmt.Errorf("invalid selection %q", part)
+		}
+		end, err := strconv.Atoi(strings.TrimSpace(last))
+		if err != nil {
+			return nil, fmt.Errorf("invalid selection %q", part)
+		}
+		if start < 1 || end > count || end < start {
+			return nil, fmt.Errorf("selection %q is outside 1-%d", part, count)
+		}
+		for i := start; i <= end; i++ {
+			indexes = append(indexes, i-1)
+		}
+	}
+	return indexes, nil
+}
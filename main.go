@@ -1,33 +1,445 @@
 package main
 
 import (
+	"cmp"
+	"encoding/json"
+	"errors"
 	"export-recordings/api"
 	"flag"
 	"fmt"
 	"log"
 	"log/slog"
+	"math"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
+// version is reported in the default User-Agent, set it when building with
+// -ldflags "-X main.version=1.2.3".
+var version = "dev"
+
 func main() {
+	result, err := run(os.Args[1:])
+	var usage usageError
+	switch {
+	case errors.Is(err, flag.ErrHelp):
+		return
+	case errors.As(err, &usage):
+		// The flag set already printed the problem and the usage
+		os.Exit(2)
+	}
+	result.log()
+	if err != nil {
+		fatal(err)
+	}
+}
+
+// run performs an export as configured by the command line arguments args
+// and returns its outcome. On errors the result covers the work done until
+// then.
+func run(args []string) (_ *RunResult, runErr error) {
+	fs := flag.NewFlagSet(filepath.Base(os.Args[0]), flag.ContinueOnError)
+	// Get options
+	pvwaAddress := fs.String("baseURL", "https://pvwa.example.com", "The base URL for PVWA")
+	username := fs.String("username", "svc-session-checker", "The username for a user with auditor rights")
+	monthsFlag := fs.String("months", "1-12", "Months to process (e.g. '5,6,7' or '1-12')")
+	year := fs.Int("year", time.Now().UTC().Year(), "Year of the months to process")
+	strictMonths := fs.Bool("strict-months", false, "Fail instead of skipping selected months that are entirely in the future")
+	fromFlag := fs.String("from", "", "Start date (YYYY-MM-DD) of a range to export instead of months")
+	toFlag := fs.String("to", "", "End date (YYYY-MM-DD, inclusive) of a range to export instead of months")
+	daysFlag := fs.String("days", "", "Day range within one month to export instead of months (e.g. '2024-03-01:2024-03-05')")
+	fromTimeFlag := fs.Int64("fromtime", 0, "Raw Unix timestamp passed to the API as fromtime, bypassing months")
+	toTimeFlag := fs.Int64("totime", 0, "Raw Unix timestamp passed to the API as totime, bypassing months")
+	sinceOutput := fs.Bool("since-output", false, "Only export recordings that started after the newest one already in the output directory")
+	latest := fs.Int("latest", 0, "Export only the N most recent recordings, ignoring the month and range flags")
+	outputFlag := fs.String("output", "downloaded_recordings", "Directory the export is written to, or '-' to write the metadata to stdout")
+	metadataOutput := fs.String("metadata-output", "", "Directory the metadata is written to instead of -output")
+	videoOutput := fs.String("video-output", "", "Directory the videos are written to instead of -output")
+	dirMode := fs.String("dir-mode", "0755", "Permissions (octal) of created output directories")
+	fileMode := fs.String("file-mode", "0644", "Permissions (octal) of created output files")
+	overwritePolicy := fs.String("overwrite-policy", "overwrite", "What to do with existing output files: 'overwrite', 'skip' or 'rename'")
+	worm := fs.Bool("worm", false, "Write-once mode: never replace existing files, failing instead, and write a sealed manifest of the run")
+	directWrite := fs.Bool("direct-write", false, "Write videos straight to their final name instead of renaming a .partial file, for storage that forbids renames")
+	archiveFormat := fs.String("archive", "", "Pack each month's output into a single archive ('tar.gz' or 'zip')")
+	seal := fs.Bool("seal", false, "Seal every finished period with a SEAL.sha256 listing of its files and a SEAL.json holding the single SHA-256 of that listing")
+	archiveRemove := fs.Bool("archive-remove", false, "Delete the loose files after a month has been archived")
+	postDownloadHook := fs.String("post-download-hook", "", "Command to run after each download; receives the file path as last argument")
+	postDownloadHookFatal := fs.Bool("post-download-hook-fatal", false, "Abort the export when the post-download hook fails")
+	connectionComponents := fs.String("connection-component", "", "Only export recordings of these connection components (e.g. 'PSM-SSH,PSM-RDP')")
+	search := fs.String("search", "", "Only export recordings matching this free-text term, searched by PVWA within every period")
+	severity := fs.String("severity", "", "Only export recordings of these severities (e.g. 'High' or 'High,Medium')")
+	remoteMachine := fs.String("remote-machine", "", "Only export recordings of sessions to these machines (e.g. 'srv01,10.0.0.5')")
+	fromIP := fs.String("from-ip", "", "Only export recordings of sessions from these source IP addresses or CIDR prefixes (e.g. '10.1.2.3,192.168.0.0/16')")
+	minDuration := fs.String("min-duration", "", "Only export recordings lasting at least this long, in seconds or as a duration (e.g. '90' or '5m')")
+	minRiskScore := fs.Float64("min-risk-score", 0, "Only export recordings with at least this risk score; recordings without one are kept unless -drop-unscored is set")
+	dropUnscored := fs.Bool("drop-unscored", false, "Leave out recordings PVWA returned no risk score for, e.g. sessions that weren't analyzed")
+	nameCollisionSafe := fs.Bool("name-collision-safe", false, "Name files <SessionID>_<SessionGuid> and write an index.json mapping them to sessions")
+	perSessionDir := fs.Bool("per-session-dir", false, "Store each session's video and metadata in its own <SessionID> folder")
+	partitionByDate := fs.Bool("partition-by-date", false, "Store video and metadata files in YYYY/MM/DD folders by the recording's start date instead of by period")
+	fields := fs.String("fields", "", "Only write these metadata fields for every recording (e.g. 'SessionID,User,Start,End')")
+	jsonMode := fs.String("json-mode", "files", "How metadata is saved: 'files' (one JSON file per session), 'ndjson' (one recordings.ndjson per period) or 'none' (only with -parquet)")
+	parquetOutput := fs.Bool("parquet", false, "Also save the metadata as Parquet files partitioned by month below <output>/parquet")
+	enrich := fs.Bool("enrich", false, "Merge the details of every recording into its metadata, one extra request per recording")
+	includeRawResponse := fs.Bool("include-raw-response", false, "Also save the raw API response of every page next to the parsed metadata")
+	backfill := fs.Bool("backfill", false, "Resumable export of all selected months, keeping progress in "+checkpointName+" so a rerun continues where it stopped")
+	sessionsFile := fs.String("sessions-file", "", "Only download the SessionIDs listed in this file, one per line (e.g. a retry-sessions.txt)")
+	runParameters := fs.String("run-parameters", "run-parameters.json", "File the resolved parameters of the run are written to, relative to the output directory; empty disables it")
+	expectedSessions := fs.String("expected-sessions", "", "CSV file of the sessions that should be exported (SessionID column, optional Start); writes a present/missing/unexpected report")
+	csvDelimiter := fs.String("csv-delimiter", ",", "Field delimiter of the CSV files read and written, a single character (e.g. ';') or 'tab'")
+	csvBOM := fs.Bool("csv-bom", false, "Start written CSV files with a UTF-8 byte order mark, for spreadsheets that need it")
+	reconciliationReport := fs.String("reconciliation-report", "reconciliation.csv", "File the -expected-sessions report is written to, relative to the output directory")
+	retryFile := fs.String("retry-file", "retry-sessions.txt", "File the SessionIDs of failed downloads are written to, relative to the output directory; empty disables it")
+	interactive := fs.Bool("interactive", false, "List the recordings of each period and pick the ones to download; ignored when not run in a terminal")
+	reviewDelta := fs.String("review-delta", "", "Directory of an earlier export; only report recording files whose review state changed since then, without downloading")
+	countOnly := fs.Bool("count-only", false, "Only print the number of recordings per month, without downloading")
+	estimate := fs.Bool("estimate", false, "Only print the expected download size and duration per month from the metadata, without downloading")
+	listFormats := fs.Bool("list-formats", false, "List the recording files (type, format, size) every session offers without downloading them")
+	estimateThroughput := fs.Float64("estimate-throughput", 10, "Download throughput in MiB/s assumed by -estimate")
+	activeWindow := fs.String("active-window", "", "Only start downloads within this daily window, e.g. '22:00-06:00' or '22:00-06:00 Europe/Berlin'; downloads pause outside it")
+	maxRuntime := fs.Duration("max-runtime", 0, "Stop starting downloads this long after the start (e.g. 5h30m) and exit listing what's left; 0 runs until done")
+	progressEvents := fs.String("progress-events", "", "Write download events (started, progress, completed, failed) as NDJSON to this file, or 'stderr'")
+	workers := fs.Int("workers", 1, "Number of recordings to download concurrently")
+	workerRamp := fs.Duration("worker-ramp", 2*time.Second, "Maximum random delay before each worker starts downloading")
+	strict := fs.Bool("strict", false, "Fail the run if any recording failed to download or its size doesn't match the metadata, after downloading all others")
+	maxConsecutiveFailures := fs.Int("max-consecutive-failures", 0, "Skip failed downloads and trip the circuit breaker after this many consecutive failures (0 stops at the first failure)")
+	breakerCooldown := fs.Duration("breaker-cooldown", 0, "Pause downloads this long when the circuit breaker trips (0 aborts the run instead)")
+	playTrailingSlash := fs.String("play-trailing-slash", "auto", "Trailing slash of the Play URL: 'always' (.../Play/), 'never' (.../Play) or 'auto' to try the other form on 404")
+	streamRetries := fs.Int("stream-retries", 2, "Restart a video download that breaks off mid-stream from the beginning up to this many times")
+	authRetries := fs.Int("auth-retries", 0, "Retry the initial logon this many times while PVWA is unreachable or answers with a server error")
+	authRetryDelay := fs.Duration("auth-retry-delay", 5*time.Second, "Wait before the first logon retry, doubled after every further one")
+	tokenSkewMargin := fs.Duration("token-skew-margin", 60*time.Second, "Renew the auth token this long before -token-lifetime runs out, to allow for clock skew")
+	userAgent := fs.String("user-agent", "export-cyberark-recordings/"+version, "User-Agent sent with every request to PVWA")
+	httpVersion := fs.String("http-version", "2", "HTTP version to use with PVWA: '2' negotiates HTTP/2 and falls back to HTTP/1.1, '1.1' never uses HTTP/2")
+	idleConnTimeout := fs.Duration("idle-conn-timeout", 90*time.Second, "How long an idle keep-alive connection to PVWA is kept open for reuse")
+	keepAlive := fs.Duration("keep-alive", 30*time.Second, "Interval of TCP keep-alive probes on connections to PVWA; negative disables them")
+	tlsMinVersion := fs.String("tls-min-version", "1.2", "Minimum TLS version accepted from PVWA (e.g. '1.2' or '1.3')")
+	tlsCiphers := fs.String("tls-ciphers", "", "Comma-separated TLS 1.2 cipher suites to allow (e.g. 'TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384'); Go's defaults when empty")
+	passwordFile := fs.String("password-file", "", "Read the password from this file instead of PVWA_PASSWORD, one per line; later lines are tried when a password is rejected")
+	tokenLifetime := fs.Duration("token-lifetime", 0, "Renew the auth token by logging in again before it is this old (e.g. 20m); 0 never renews")
+	dryRunAuth := fs.Bool("dry-run-auth", false, "Validate the options and print the effective configuration without contacting PVWA")
+	debug := fs.Bool("debug", false, "Enable debug logging")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil, err
+		}
+		return nil, usageError{err}
+	}
+	// The deadline counts from the start, logging in and listing included
+	var deadline time.Time
+	if *maxRuntime > 0 {
+		deadline = time.Now().Add(*maxRuntime)
+	}
+
 	// Configure structured logging
-	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
-		Level: slog.LevelInfo,
+	// Log through the console so log lines don't garble the progress line
+	logLevel := slog.LevelInfo
+	if *debug {
+		logLevel = slog.LevelDebug
+	}
+	logger := slog.New(slog.NewTextHandler(pvwaAPI.Console, &slog.HandlerOptions{
+		Level: logLevel,
 	}))
 	slog.SetDefault(logger)
 
+	// With "-output -" stdout carries the metadata, so everything else
+	// has to go to stderr
+	toStdout := *outputFlag == "-"
+	if toStdout {
+		pvwaAPI.Console.SetOutput(os.Stderr)
+		if *archiveFormat != "" || *includeRawResponse || *parquetOutput || *metadataOutput != "" || *videoOutput != "" {
+			return nil, errors.New("-archive, -include-raw-response, -parquet, -metadata-output and -video-output need an output directory and cannot be used with '-output -'")
+		}
+	}
+
 	slog.Info("starting recording export")
-	// Get options
-	pvwaAddress := flag.String("baseURL", "https://pvwa.example.com", "The base URL for PVWA")
-	username := flag.String("username", "svc-session-checker", "The username for a user with auditor rights")
-	monthsFlag := flag.String("months", "1-12", "Months to process (e.g. '5,6,7' or '1-12')")
-	flag.Parse()
 
-	// Parse months flag
-	var months []int = parseMonths(*monthsFlag)
+	var window *pvwaAPI.ActiveWindow
+	if *activeWindow != "" {
+		var err error
+		window, err = pvwaAPI.ParseActiveWindow(*activeWindow)
+		if err != nil {
+			return nil, err
+		}
+	}
+	playSlash, err := pvwaAPI.ParsePlayTrailingSlash(*playTrailingSlash)
+	if err != nil {
+		return nil, err
+	}
+	if *workers < 1 {
+		return nil, errors.New("workers must be at least 1")
+	}
+	if *maxRuntime < 0 {
+		return nil, errors.New("max-runtime cannot be negative")
+	}
+	if *tokenLifetime < 0 || *tokenSkewMargin < 0 {
+		return nil, errors.New("token-lifetime and token-skew-margin cannot be negative")
+	}
+	// Otherwise every token counts as expired once issued, and each request
+	// logs on again
+	if *tokenLifetime > 0 && *tokenLifetime <= *tokenSkewMargin {
+		return nil, fmt.Errorf("-token-lifetime %s must be longer than -token-skew-margin %s", *tokenLifetime, *tokenSkewMargin)
+	}
+	if *estimate && (*countOnly || *reviewDelta != "" || *interactive) {
+		return nil, errors.New("-estimate cannot be used with -count-only, -review-delta or -interactive")
+	}
+	if *listFormats && (*countOnly || *estimate || *reviewDelta != "" || *interactive || *expectedSessions != "") {
+		return nil, errors.New("-list-formats cannot be used with -count-only, -estimate, -review-delta, -interactive or -expected-sessions")
+	}
+	if *estimateThroughput <= 0 {
+		return nil, errors.New("estimate-throughput must be greater than 0")
+	}
+	if *authRetries < 0 {
+		return nil, errors.New("auth-retries cannot be negative")
+	}
+	pvwaAPI.AuthRetries = *authRetries
+	pvwaAPI.PasswordFile = *passwordFile
+	pvwaAPI.AuthRetryDelay = *authRetryDelay
+	if *streamRetries < 0 {
+		return nil, errors.New("stream-retries cannot be negative")
+	}
+
+	if *jsonMode != "files" && *jsonMode != "ndjson" && *jsonMode != "none" {
+		return nil, errors.New("invalid json mode. Use 'files', 'ndjson' or 'none'")
+	}
+	if *jsonMode == "none" && !*parquetOutput {
+		return nil, errors.New("-json-mode none needs -parquet, otherwise no metadata would be saved")
+	}
+
+	if *archiveFormat != "" && *archiveFormat != "tar.gz" && *archiveFormat != "zip" {
+		return nil, errors.New("invalid archive format. Use 'tar.gz' or 'zip'")
+	}
+
+	if *worm && *archiveRemove {
+		return nil, errors.New("-archive-remove deletes files and cannot be used with -worm")
+	}
+
+	if *backfill && (toStdout || *worm) {
+		return nil, errors.New("-backfill keeps a checkpoint next to the export and cannot be used with '-output -' or -worm")
+	}
+
+	if *partitionByDate && (*archiveFormat != "" || *seal) {
+		return nil, errors.New("-partition-by-date spreads a period over date folders and cannot be used with -archive or -seal")
+	}
+
+	if *seal && toStdout {
+		return nil, errors.New("-seal needs files to seal and cannot be used with '-output -'")
+	}
+	if *interactive && toStdout {
+		return nil, errors.New("-interactive needs the terminal and cannot be used with '-output -'")
+	}
+	if *interactive && !canSelectInteractively() {
+		slog.Warn("not running in a terminal, downloading without interactive selection")
+		*interactive = false
+	}
+
+	if *expectedSessions != "" && (toStdout || *countOnly || *estimate || *reviewDelta != "") {
+		return nil, errors.New("-expected-sessions reconciles downloads and cannot be used with '-output -', -count-only, -estimate or -review-delta")
+	}
+
+	delimiter, err := parseCSVDelimiter(*csvDelimiter)
+	if err != nil {
+		return nil, err
+	}
+	dialect := csvDialect{delimiter: delimiter, bom: *csvBOM}
+
+	if *backfill && *sessionsFile != "" {
+		return nil, errors.New("-backfill exports whole months and cannot be used with -sessions-file")
+	}
+
+	if *fields != "" {
+		pvwaAPI.Fields = pvwaAPI.ParseFields(splitList(*fields))
+	}
+	pvwaAPI.PerSessionDir = *perSessionDir
+	pvwaAPI.PartitionByDate = *partitionByDate
+	pvwaAPI.UniqueNames = *nameCollisionSafe
+	policy, err := pvwaAPI.ParseOverwritePolicy(*overwritePolicy)
+	if err != nil {
+		return nil, err
+	}
+	pvwaAPI.Overwrite = policy
+	pvwaAPI.WORM = *worm
+	pvwaAPI.DirectWrite = *directWrite
+	if strings.TrimSpace(*userAgent) == "" {
+		return nil, errors.New("-user-agent cannot be empty")
+	}
+	pvwaAPI.UserAgent = *userAgent
+	pvwaAPI.HTTPVersion, err = pvwaAPI.ParseHTTPVersion(*httpVersion)
+	if err != nil {
+		return nil, err
+	}
+	pvwaAPI.IdleConnTimeout = *idleConnTimeout
+	pvwaAPI.KeepAlive = *keepAlive
+	// Every worker keeps its connection when HTTP/1.1 is used
+	pvwaAPI.MaxIdleConnsPerHost = *workers + 1
+	tlsVersion, err := pvwaAPI.ParseTLSVersion(*tlsMinVersion)
+	if err != nil {
+		return nil, err
+	}
+	pvwaAPI.TLSConfig.MinVersion = tlsVersion
+	if *tlsCiphers != "" {
+		pvwaAPI.TLSConfig.CipherSuites, err = pvwaAPI.ParseCipherSuites(splitList(*tlsCiphers))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if pvwaAPI.DirMode, err = parseFileMode("dir-mode", *dirMode); err != nil {
+		return nil, err
+	}
+	if pvwaAPI.FileMode, err = parseFileMode("file-mode", *fileMode); err != nil {
+		return nil, err
+	}
+
+	// Filters applied to the recordings of every period after retrieval
+	var filters []recordingFilter
+	if *connectionComponents != "" {
+		filters = append(filters, recordingFilter{
+			name: "connection component",
+			keep: pvwaAPI.ByConnectionComponent(splitList(*connectionComponents)),
+		})
+	}
+
+	if *severity != "" {
+		filters = append(filters, recordingFilter{
+			name: "severity",
+			keep: pvwaAPI.BySeverity(splitList(*severity)),
+		})
+	}
+
+	if *remoteMachine != "" {
+		filters = append(filters, recordingFilter{
+			name: "remote machine",
+			keep: pvwaAPI.ByRemoteMachine(splitList(*remoteMachine)),
+		})
+	}
+
+	if *fromIP != "" {
+		keep, err := pvwaAPI.ByFromIP(splitList(*fromIP))
+		if err != nil {
+			return nil, fmt.Errorf("invalid -from-ip: %w", err)
+		}
+		filters = append(filters, recordingFilter{
+			name: "source IP",
+			keep: keep,
+		})
+	}
+
+	if *minDuration != "" {
+		minimum, err := parseDuration("min-duration", *minDuration)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, recordingFilter{
+			name: "minimum duration",
+			keep: pvwaAPI.ByMinDuration(minimum),
+		})
+	}
+
+	if *minRiskScore < 0 {
+		return nil, errors.New("min-risk-score cannot be negative")
+	}
+	if *minRiskScore > 0 {
+		filters = append(filters, recordingFilter{
+			name: "minimum risk score",
+			keep: pvwaAPI.ByMinRiskScore(*minRiskScore),
+		})
+	}
+
+	if *dropUnscored {
+		filters = append(filters, recordingFilter{
+			name: "risk score reported",
+			keep: pvwaAPI.ByRiskScored,
+		})
+	}
+
+	useRange := *fromFlag != "" || *toFlag != ""
+	useTimestamps := *fromTimeFlag != 0 || *toTimeFlag != 0
+	useDays := *daysFlag != ""
+	if (useRange && useTimestamps) || (useRange && useDays) || (useTimestamps && useDays) {
+		return nil, errors.New("only one of -from/-to, -fromtime/-totime and -days can be used")
+	}
+	if *latest < 0 {
+		return nil, errors.New("-latest must not be negative")
+	}
+	if *sinceOutput && (useRange || useTimestamps || useDays || *latest > 0 || toStdout) {
+		return nil, errors.New("-since-output derives the range itself and cannot be combined with other ranges or '-output -'")
+	}
+	var from, to time.Time
+	if useRange {
+		if from, to, err = parseRange(*fromFlag, *toFlag); err != nil {
+			return nil, err
+		}
+	}
+	if useDays {
+		if from, to, err = parseDays(*daysFlag); err != nil {
+			return nil, err
+		}
+	}
+	if useTimestamps {
+		if *fromTimeFlag == 0 || *toTimeFlag == 0 {
+			return nil, errors.New("both -fromtime and -totime must be set")
+		}
+		if *toTimeFlag < *fromTimeFlag {
+			return nil, errors.New("-totime must not be before -fromtime")
+		}
+		from, to = time.Unix(*fromTimeFlag, 0).UTC(), time.Unix(*toTimeFlag, 0).UTC()
+	}
+
+	if err := validateBaseURL(*pvwaAddress); err != nil {
+		return nil, err
+	}
+	months, err := parseMonths(*monthsFlag)
+	if err != nil {
+		return nil, err
+	}
+	// PVWA timestamps are Unix times, earlier years can't hold recordings
+	if *year < 1970 || *year > 9999 {
+		return nil, fmt.Errorf("invalid -year %d", *year)
+	}
+
+	useSince := false
+	if *sinceOutput {
+		newest, err := newestExportedStart(filepath.Clean(cmp.Or(*metadataOutput, *outputFlag)))
+		if err != nil {
+			return nil, err
+		}
+		if newest.IsZero() {
+			slog.Info("no earlier export found in the output directory, exporting the selected months", "output", *outputFlag)
+		} else {
+			useSince = true
+			from, to = newest.Add(time.Second), time.Now().UTC()
+			slog.Info("continuing after the newest exported recording", "start", newest, "from", from, "to", to)
+		}
+	}
+
+	if *latest == 0 && !useSince && !useRange && !useTimestamps && !useDays {
+		if months, err = skipFutureMonths(months, *year, time.Now(), *strictMonths); err != nil {
+			return nil, err
+		}
+	}
+
+	if *dryRunAuth {
+		return nil, printEffectiveConfig(os.Stdout, fs)
+	}
+
+	// Opened only now, a dry run must not create or truncate the file
+	switch *progressEvents {
+	case "":
+	case "stderr":
+		pvwaAPI.Events = os.Stderr
+	default:
+		// Create works for named pipes too, which an orchestrator may read
+		events, err := os.Create(*progressEvents)
+		if err != nil {
+			return nil, fmt.Errorf("error creating progress events file: %w", err)
+		}
+		defer events.Close()
+		pvwaAPI.Events = events
+	}
 
 	// Initialize the client
 	pvwaClient, err := pvwaAPI.NewPVWAConfig(
@@ -36,49 +448,821 @@ func main() {
 	)
 
 	if err != nil {
-		log.Fatal("error at pvwaClient: \n", err)
+		return nil, fmt.Errorf("error at pvwaClient: %w", err)
 	}
 
-	for _, m := range months {
-		slog.Info("processing month", "month", m)
+	// Fail fast, before any output is created, if the appliance isn't ready
+	if err := pvwaClient.Ping(); err != nil {
+		return nil, fmt.Errorf("preflight check failed, aborting before the export starts: %w", err)
+	}
+	slog.Info("preflight check passed", "baseURL", redactURL(pvwaClient.BaseURL))
+
+	pvwaClient.PostDownloadHook = *postDownloadHook
+	pvwaClient.PostDownloadHookFatal = *postDownloadHookFatal
+	pvwaClient.Workers = *workers
+	pvwaClient.WorkerRamp = *workerRamp
+	pvwaClient.StreamRetries = *streamRetries
+	pvwaClient.Search = *search
+	pvwaClient.ActiveWindow = window
+	pvwaClient.Deadline = deadline
+	pvwaClient.PlayTrailingSlash = playSlash
+	pvwaClient.KeepRawResponses = *includeRawResponse
+	pvwaClient.MaxConsecutiveFailures = *maxConsecutiveFailures
+	if *strict && *maxConsecutiveFailures < 1 {
+		// Everything that can be downloaded is, the run fails at the end
+		pvwaClient.MaxConsecutiveFailures = math.MaxInt
+	}
+	pvwaClient.BreakerCooldown = *breakerCooldown
+	pvwaClient.TokenLifetime = *tokenLifetime
+	pvwaClient.TokenSkewMargin = *tokenSkewMargin
+
+	// Work out which periods to export
+	var periods []exportPeriod
+	if *latest > 0 {
+		periods = append(periods, exportPeriod{
+			name: "latest",
+			fetch: func() (*pvwaAPI.SessionRecordings, error) {
+				sessions, err := pvwaClient.GetLatestRecordings(*latest)
+				if err == nil && len(sessions.Recordings) > 0 {
+					oldest, newest := sessions.Recordings[0].Start, sessions.Recordings[0].Start
+					for _, r := range sessions.Recordings {
+						oldest, newest = min(oldest, r.Start), max(newest, r.Start)
+					}
+					slog.Info("latest recordings cover",
+						"count", len(sessions.Recordings),
+						"from", time.Unix(oldest, 0).UTC(),
+						"to", time.Unix(newest, 0).UTC())
+				}
+				return sessions, err
+			},
+			count: func() (int, error) {
+				total, err := pvwaClient.CountRecordings(map[string]string{})
+				return min(total, *latest), err
+			},
+		})
+	} else if useSince {
+		periods = append(periods, exportPeriod{
+			name: fmt.Sprintf("%d_%d", from.Unix(), to.Unix()),
+			from: from,
+			to:   to,
+			fetch: func() (*pvwaAPI.SessionRecordings, error) {
+				return pvwaClient.GetRecordingsComplete(from, to)
+			},
+			count: func() (int, error) {
+				return pvwaClient.CountRecordingsByRange(from, to)
+			},
+		})
+	} else if useTimestamps {
+		periods = append(periods, exportPeriod{
+			name: fmt.Sprintf("%d_%d", from.Unix(), to.Unix()),
+			from: from,
+			to:   to,
+			fetch: func() (*pvwaAPI.SessionRecordings, error) {
+				return pvwaClient.GetRecordingsByRange(from, to)
+			},
+			count: func() (int, error) {
+				return pvwaClient.CountRecordingsByRange(from, to)
+			},
+		})
+	} else if useDays {
+		periods = append(periods, exportPeriod{
+			name: from.Format("2006-01-02") + "_" + to.Format("2006-01-02"),
+			from: from,
+			to:   to,
+			fetch: func() (*pvwaAPI.SessionRecordings, error) {
+				return pvwaClient.GetRecordingsByRange(from, to)
+			},
+			count: func() (int, error) {
+				return pvwaClient.CountRecordingsByRange(from, to)
+			},
+		})
+	} else if useRange {
+		periods = append(periods, exportPeriod{
+			name: from.Format("2006-01-02") + "_" + to.Format("2006-01-02"),
+			from: from,
+			to:   to,
+			fetch: func() (*pvwaAPI.SessionRecordings, error) {
+				return pvwaClient.GetRecordingsComplete(from, to)
+			},
+			count: func() (int, error) {
+				return pvwaClient.CountRecordingsByRange(from, to)
+			},
+		})
+	} else {
+		for _, m := range months {
+			monthFrom, monthTo := pvwaAPI.MonthRange(*year, m)
+			periods = append(periods, exportPeriod{
+				name: monthFrom.Format("2006-01"),
+				from: monthFrom,
+				to:   monthTo,
+				fetch: func() (*pvwaAPI.SessionRecordings, error) {
+					return pvwaClient.GetRecordingsByMonth(*year, m)
+				},
+				count: func() (int, error) {
+					return pvwaClient.CountRecordingsByMonth(*year, m)
+				},
+			})
+		}
+	}
+
+	result := &RunResult{CountOnly: *countOnly, Estimate: *estimate}
+
+	if *countOnly {
+		for _, period := range periods {
+			count, err := period.count()
+			if err != nil {
+				return result, fmt.Errorf("error counting recordings for period %s: %w", period.name, err)
+			}
+			result.Periods = append(result.Periods, PeriodResult{Name: period.name, Recordings: count})
+			result.Recordings += count
+		}
+		if toStdout {
+			return result, result.writeCountsJSON(os.Stdout)
+		}
+		result.writeCountsTable(os.Stdout)
+		return result, nil
+	}
+
+	if *estimate {
+		for _, period := range periods {
+			sessions, err := period.fetch()
+			if err != nil {
+				return result, fmt.Errorf("error getting recordings for period %s: %w", period.name, err)
+			}
+			for _, filter := range filters {
+				sessions = sessions.Filter(filter.keep)
+			}
+			estimated := PeriodResult{Name: period.name, Recordings: len(sessions.Recordings)}
+			unsized := 0
+			for _, r := range sessions.Recordings {
+				if !r.Reported("VideoSize") && !r.Reported("TextSize") {
+					unsized++
+				}
+				estimated.VideoSize += int64(r.VideoSize)
+				estimated.TextSize += int64(r.TextSize)
+			}
+			if unsized > 0 {
+				slog.Warn("PVWA reported no size for some recordings, they are not in the estimate",
+					"period", period.name,
+					"recordings", unsized)
+			}
+			result.add(estimated)
+		}
+		throughput := *estimateThroughput * 1024 * 1024
+		if toStdout {
+			return result, result.writeEstimateJSON(os.Stdout, throughput)
+		}
+		result.writeEstimateTable(os.Stdout, throughput)
+		return result, nil
+	}
+
+	if *listFormats {
+		var listing formatListing
+		for _, period := range periods {
+			sessions, err := period.fetch()
+			if err != nil {
+				return result, fmt.Errorf("error getting recordings for period %s: %w", period.name, err)
+			}
+			for _, filter := range filters {
+				sessions = sessions.Filter(filter.keep)
+			}
+			listing.add(period.name, sessions)
+			result.add(PeriodResult{Name: period.name, Recordings: len(sessions.Recordings)})
+		}
+		if toStdout {
+			return result, listing.writeJSON(os.Stdout)
+		}
+		listing.writeTable(os.Stdout)
+		return result, nil
+	}
+
+	// Metadata and videos can live on different storage, the run's own
+	// files (audit log, checkpoint, manifest) stay in -output
+	outputRoot := filepath.Clean(*outputFlag)
+	metadataRoot := filepath.Clean(cmp.Or(*metadataOutput, *outputFlag))
+	videoRoot := filepath.Clean(cmp.Or(*videoOutput, *outputFlag))
+	audit := auditEvent{
+		RunID:      newRunID(),
+		Event:      "started",
+		Time:       time.Now().UTC(),
+		Username:   pvwaClient.Username,
+		BaseURL:    redactURL(pvwaClient.BaseURL),
+		Parameters: explicitFlags(fs),
+	}
+	result.RunID = audit.RunID
+	// Nothing is written to disk when streaming to stdout, so there is
+	// no output directory to keep the audit log in either
+	if !toStdout {
+		if err := appendAuditEvent(outputRoot, audit); err != nil {
+			return result, fmt.Errorf("error writing audit log: %w", err)
+		}
+	}
+	// finishRun is set once everything it writes is known, and auditClosed
+	// once it wrote the closing event
+	var finishRun func(event string) error
+	var auditClosed bool
+	// bookkeeping guards result and audit against the flush of an
+	// interrupted run
+	var bookkeeping sync.Mutex
+	// Every other error still closes the run in the audit log, so it isn't
+	// taken for one that was killed
+	defer func() {
+		if runErr == nil || auditClosed || toStdout {
+			return
+		}
+		bookkeeping.Lock()
+		defer bookkeeping.Unlock()
+		audit.Error = runErr.Error()
+		if finishRun != nil {
+			if err := finishRun("failed"); err != nil {
+				slog.Error("error saving the state of the failed run", "error", err)
+			}
+			return
+		}
+		audit.Event = "failed"
+		audit.Time = time.Now().UTC()
+		if err := appendAuditEvent(outputRoot, audit); err != nil {
+			slog.Error("error writing audit log", "error", err)
+		}
+	}()
+	if *runParameters != "" && !toStdout {
+		path := *runParameters
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(outputRoot, path)
+		}
+		if err := writeRunParameters(path, audit.RunID, fs, periods); err != nil {
+			return result, err
+		}
+	}
+
+	var backfillState *checkpoint
+	if *backfill {
+		backfillState, err = loadCheckpoint(outputRoot)
+		if err != nil {
+			return result, err
+		}
+	}
 
-		sessions, err := pvwaClient.GetRecordingsByMonth(m)
+	var onlySessions func(pvwaAPI.Recording) bool
+	if *sessionsFile != "" {
+		ids, err := readSessionsFile(*sessionsFile)
+		if err != nil {
+			return result, err
+		}
+		slog.Info("downloading listed sessions only", "file", *sessionsFile, "sessions", len(ids))
+		onlySessions = pvwaAPI.BySessionIDs(ids)
+	}
+	var expected *sessionReconciliation
+	if *expectedSessions != "" {
+		sessions, err := readExpectedSessions(*expectedSessions, dialect)
+		if err != nil {
+			return result, err
+		}
+		slog.Info("loaded expected sessions", "file", *expectedSessions, "sessions", len(sessions))
+		expected = newSessionReconciliation(sessions)
+	}
+	var previous *pvwaAPI.SessionRecordings
+	if *reviewDelta != "" {
+		previous, err = pvwaAPI.LoadRecordings(*reviewDelta)
 		if err != nil {
-			log.Fatal("error getting recordings for month: ", m, "\n", err)
+			return result, err
+		}
+		slog.Info("loaded earlier export", "directory", *reviewDelta, "recordings", len(previous.Recordings))
+	}
+
+	// finishRun writes the bookkeeping of the run: the retry file, the
+	// WORM manifest and the final audit event
+	finishRun = func(event string) error {
+		if *retryFile != "" && !toStdout && previous == nil {
+			path := *retryFile
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(outputRoot, path)
+			}
+			if err := writeRetryFile(path, result.Failures); err != nil {
+				return err
+			}
+			if len(result.Failures) > 0 {
+				slog.Warn("failed sessions can be retried", "sessions", len(result.Failures), "retry", "-sessions-file "+path)
+			}
+		}
+
+		if *worm && !toStdout {
+			if _, err := pvwaAPI.SaveManifest(outputRoot, audit.RunID); err != nil {
+				return fmt.Errorf("error saving manifest: %w", err)
+			}
+		}
+
+		if !toStdout {
+			audit.Event = event
+			audit.Time = time.Now().UTC()
+			if err := appendAuditEvent(outputRoot, audit); err != nil {
+				return fmt.Errorf("error writing audit log: %w", err)
+			}
+			auditClosed = true
+		}
+		return nil
+	}
+
+	// An interrupted run still leaves its bookkeeping behind, covering the
+	// periods finished so far
+	stopFlush := flushOnInterrupt(&bookkeeping, func() {
+		result.log()
+		if err := finishRun("interrupted"); err != nil {
+			slog.Error("error saving the state of the interrupted run", "error", err)
+		}
+	})
+	defer stopFlush()
+
+	var leftPeriods []string
+	for _, period := range periods {
+		if backfillState != nil && backfillState.periodDone(period.name) {
+			slog.Info("skipping period completed by an earlier backfill", "period", period.name)
+			continue
+		}
+		if !deadline.IsZero() && !time.Now().Before(deadline) {
+			leftPeriods = append(leftPeriods, period.name)
+			continue
+		}
+		slog.Info("processing period", "period", period.name)
+
+		sessions, err := period.fetch()
+		if err != nil {
+			return result, fmt.Errorf("error getting recordings for period %s: %w", period.name, err)
 		}
 
 		slog.Info("found recordings",
-			"month", m,
+			"period", period.name,
 			"count", sessions.Total,
 			"retrieved", len(sessions.Recordings))
-		outputPath := filepath.Join(".", "downloaded_recordings/", fmt.Sprintf("%d/", m))
-		sessions.SaveToJSON(outputPath)
-		pvwaClient.DownloadRecordings(outputPath, sessions)
+		retrieved, apiTotal := len(sessions.Recordings), sessions.Total
+		retrievedSessions := sessions
+		if period.from.IsZero() {
+			// The Total of -latest counts all recordings, not just the newest
+			apiTotal = min(apiTotal, *latest)
+		}
+
+		for _, filter := range filters {
+			filtered := sessions.Filter(filter.keep)
+			slog.Info("filtered recordings",
+				"period", period.name,
+				"filter", filter.name,
+				"kept", len(filtered.Recordings),
+				"removed", len(sessions.Recordings)-len(filtered.Recordings))
+			sessions = filtered
+		}
+
+		if *enrich {
+			if err := pvwaClient.EnrichRecordings(sessions); err != nil {
+				return result, fmt.Errorf("error enriching recordings for period %s: %w", period.name, err)
+			}
+		}
+
+		if previous != nil {
+			changes := sessions.ReviewChanges(previous)
+			slog.Info("compared review state", "period", period.name, "changes", len(changes))
+			if toStdout {
+				encoder := json.NewEncoder(os.Stdout)
+				for _, change := range changes {
+					if err := encoder.Encode(change); err != nil {
+						return result, fmt.Errorf("error writing review changes for period %s: %w", period.name, err)
+					}
+				}
+			} else if err := pvwaAPI.SaveReviewChanges(filepath.Join(metadataRoot, period.name), changes); err != nil {
+				return result, fmt.Errorf("error saving review changes for period %s: %w", period.name, err)
+			}
+			continue
+		}
+
+		if toStdout {
+			writeMetadata := sessions.WriteJSON
+			if *jsonMode == "ndjson" {
+				writeMetadata = sessions.WriteNDJSON
+			}
+			if err := writeMetadata(os.Stdout); err != nil {
+				return result, fmt.Errorf("error writing metadata for period %s: %w", period.name, err)
+			}
+			bookkeeping.Lock()
+			result.Periods = append(result.Periods, PeriodResult{Name: period.name, Recordings: len(sessions.Recordings)})
+			result.Recordings += len(sessions.Recordings)
+			bookkeeping.Unlock()
+			continue
+		}
+
+		metadataPath := filepath.Join(metadataRoot, period.name)
+		outputPath := filepath.Join(videoRoot, period.name)
+		// Date partitions are shared by all periods, so the per-recording
+		// files go below the roots while the per-period files stay in the
+		// period directory
+		jsonPath := metadataPath
+		if *partitionByDate {
+			outputPath, jsonPath = videoRoot, metadataRoot
+		}
+		saveMetadata := sessions.SaveToJSON
+		if *jsonMode == "ndjson" {
+			saveMetadata = sessions.SaveToNDJSON
+			jsonPath = metadataPath
+		}
+		if *jsonMode != "none" {
+			if err := saveMetadata(jsonPath); err != nil {
+				return result, fmt.Errorf("error saving metadata for period %s: %w", period.name, err)
+			}
+		}
+		if *parquetOutput {
+			if err := sessions.SaveToParquet(filepath.Join(metadataRoot, "parquet"), period.name); err != nil {
+				return result, fmt.Errorf("error saving Parquet metadata for period %s: %w", period.name, err)
+			}
+		}
+		if *nameCollisionSafe {
+			if err := sessions.SaveIndex(metadataPath); err != nil {
+				return result, fmt.Errorf("error saving index for period %s: %w", period.name, err)
+			}
+		}
+		if *includeRawResponse {
+			if err := sessions.SaveRawResponses(metadataPath); err != nil {
+				return result, fmt.Errorf("error saving raw responses for period %s: %w", period.name, err)
+			}
+		}
+		downloads := sessions
+		if onlySessions != nil {
+			downloads = downloads.Filter(onlySessions)
+			slog.Info("selected listed sessions",
+				"period", period.name,
+				"kept", len(downloads.Recordings),
+				"removed", len(sessions.Recordings)-len(downloads.Recordings))
+		}
+		if *interactive && len(downloads.Recordings) > 0 {
+			downloads, err = selectRecordings(period.name, downloads, os.Stdin, pvwaAPI.Console)
+			if err != nil {
+				return result, err
+			}
+			slog.Info("selected recordings", "period", period.name, "selected", len(downloads.Recordings))
+		}
+		selected := downloads
+		if backfillState != nil {
+			pending := backfillState.pending(period.name, downloads)
+			done := len(downloads.Recordings) - len(pending.Recordings)
+			downloads = pending
+			if done > 0 {
+				slog.Info("resuming backfill",
+					"period", period.name,
+					"downloaded", done,
+					"remaining", len(downloads.Recordings))
+			}
+			pvwaClient.OnDownloaded = func(r pvwaAPI.Recording) {
+				if err := backfillState.sessionDone(period.name, r.SessionID); err != nil {
+					slog.Error("error updating checkpoint", "sessionID", r.SessionID, "error", err)
+				}
+			}
+		}
+		stats, err := pvwaClient.DownloadRecordings(outputPath, downloads)
+		periodResult := PeriodResult{
+			Name:          period.name,
+			Recordings:    len(sessions.Recordings),
+			OutputPath:    outputPath,
+			MetadataPath:  metadataPath,
+			APITotal:      apiTotal,
+			Retrieved:     retrieved,
+			Selected:      len(downloads.Recordings),
+			DownloadStats: stats,
+		}
+		periodResult.reconcile()
+		bookkeeping.Lock()
+		result.add(periodResult)
+		var failed *pvwaAPI.DownloadFailedError
+		if errors.As(err, &failed) {
+			result.Failures = append(result.Failures, failed.Failures...)
+		}
+		bookkeeping.Unlock()
+		if failed != nil {
+			slog.Warn("some recordings could not be downloaded",
+				"period", period.name,
+				"failed", len(failed.Failures))
+		} else if err != nil {
+			return result, fmt.Errorf("error downloading recordings for period %s: %w", period.name, err)
+		}
+		var failures []pvwaAPI.DownloadFailure
+		if failed != nil {
+			failures = failed.Failures
+		}
+		if expected != nil {
+			expected.addPeriod(period, retrievedSessions, selected, failures)
+		}
+		// A period cut short by the deadline is finished by a later run,
+		// only then is it archived and sealed
+		unfinished := slices.ContainsFunc(failures, func(f pvwaAPI.DownloadFailure) bool {
+			return errors.Is(f.Err, pvwaAPI.ErrDeadlineReached)
+		})
+		if unfinished && (*archiveFormat != "" || *seal) {
+			slog.Warn("period not finished before the deadline, not archiving or sealing it", "period", period.name)
+		}
+
+		periodDirs := []string{outputPath}
+		if metadataPath != outputPath {
+			periodDirs = append(periodDirs, metadataPath)
+		}
+		var archives []string
+		if *archiveFormat != "" && !unfinished {
+			for _, dir := range periodDirs {
+				archivePath, err := archiveDirectory(dir, *archiveFormat, *archiveRemove)
+				if errors.Is(err, pvwaAPI.ErrSkipped) {
+					slog.Warn("archive of the period exists, keeping it and the directory", "period", period.name, "directory", dir)
+					continue
+				}
+				if err != nil {
+					return result, fmt.Errorf("error archiving period: %s: %w", period.name, err)
+				}
+				slog.Info("archived period", "period", period.name, "archive", archivePath)
+				archives = append(archives, archivePath)
+			}
+		}
+
+		if *seal && !unfinished {
+			// An archived period is sealed by its archives, which are
+			// written next to the period directories
+			sealDir, prefix, sources := metadataPath, "", periodDirs
+			if archives != nil {
+				sealDir, prefix, sources = metadataRoot, period.name, archives
+			}
+			if _, err := sealPeriod(sealDir, prefix, sources, period, len(sessions.Recordings), audit.RunID); err != nil {
+				return result, fmt.Errorf("error sealing period %s: %w", period.name, err)
+			}
+		}
 
+		auditedPeriod := auditPeriod{
+			Name:       period.name,
+			Recordings: len(sessions.Recordings),
+			OutputPath: outputPath,
+		}
+		if metadataPath != outputPath {
+			auditedPeriod.MetadataPath = metadataPath
+		}
+		bookkeeping.Lock()
+		audit.Periods = append(audit.Periods, auditedPeriod)
+		audit.Recordings += len(sessions.Recordings)
+		bookkeeping.Unlock()
+
+		// A period with failed downloads stays in progress so the next
+		// run retries them
+		if backfillState != nil && failed == nil {
+			if err := backfillState.periodCompleted(period.name); err != nil {
+				return result, err
+			}
+		}
+	}
+
+	stopFlush()
+	if expected != nil {
+		path := *reconciliationReport
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(outputRoot, path)
+		}
+		if err := writeReconciliationReport(path, audit.RunID, expected.finish(), dialect); err != nil {
+			return result, err
+		}
+	}
+
+	notStarted := 0
+	for _, f := range result.Failures {
+		if errors.Is(f.Err, pvwaAPI.ErrDeadlineReached) {
+			notStarted++
+		}
+	}
+	if notStarted > 0 || leftPeriods != nil {
+		if err := finishRun("deadline reached"); err != nil {
+			return result, err
+		}
+		slog.Warn("deadline reached, the export is incomplete",
+			"maxRuntime", *maxRuntime,
+			"sessionsNotStarted", notStarted,
+			"periodsNotStarted", leftPeriods)
+		return result, fmt.Errorf("%w: -max-runtime %s passed with %d downloads not started and %d periods not exported %v",
+			pvwaAPI.ErrDeadlineReached, *maxRuntime, notStarted, len(leftPeriods), leftPeriods)
+	}
+	if *strict && (len(result.Failures) > 0 || result.SizeMismatches > 0) {
+		if err := finishRun("failed (strict)"); err != nil {
+			return result, err
+		}
+		return result, fmt.Errorf("strict mode: %d recordings failed to download and %d failed the size check",
+			len(result.Failures), result.SizeMismatches)
+	}
+	if err := finishRun("completed"); err != nil {
+		return result, err
 	}
+	return result, nil
+}
+
+// exitPermissionDenied is the exit code used when the account is not
+// allowed to read recordings, so wrappers can tell it apart from other
+// failures.
+const exitPermissionDenied = 3
+
+// exitDeadlineReached is the exit code of a run that stopped at its
+// -max-runtime deadline before everything was exported.
+const exitDeadlineReached = 4
+
+// fatal logs err and exits. Permission errors exit with
+// exitPermissionDenied, a reached deadline with exitDeadlineReached,
+// everything else like log.Fatal.
+func fatal(err error) {
+	var permErr *pvwaAPI.PermissionError
+	if errors.As(err, &permErr) {
+		slog.Error("export failed", "error", err)
+		os.Exit(exitPermissionDenied)
+	}
+	if errors.Is(err, pvwaAPI.ErrDeadlineReached) {
+		slog.Error("export stopped", "error", err)
+		os.Exit(exitDeadlineReached)
+	}
+	log.Fatal(err)
+}
+
+// usageError is returned by run when the command line can't be parsed.
+type usageError struct {
+	error
+}
+
+// exportPeriod is one unit of work of an export: a set of recordings that
+// is retrieved in one go and written to its own output directory.
+type exportPeriod struct {
+	// name identifies the period in logs and is used as its output directory
+	name string
+	// from and to are the window the period covers, unset for -latest
+	from, to time.Time
+	// fetch retrieves the recordings of the period
+	fetch func() (*pvwaAPI.SessionRecordings, error)
+	// count returns the number of recordings of the period without retrieving them
+	count func() (int, error)
+}
+
+// recordingFilter is a named condition recordings must meet to be exported.
+type recordingFilter struct {
+	name string
+	keep func(pvwaAPI.Recording) bool
+}
+
+// splitList splits a comma-separated flag value, dropping empty entries.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseDuration parses a flag given either as a number of seconds or as a
+// Go duration string such as "5m".
+func parseDuration(name string, value string) (time.Duration, error) {
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid -%s %q, expected seconds or a duration like 5m", name, value)
+	}
+	return d, nil
+}
 
+// parseFileMode parses an octal permission flag such as "0750".
+func parseFileMode(name string, value string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("invalid -%s %q, expected octal permissions like 0750", name, value)
+	}
+	return os.FileMode(mode), nil
+}
+
+// parseRange parses the -from and -to flags. Both dates are required and
+// the end date is inclusive, so the returned end is the last second of it.
+func parseRange(fromFlag string, toFlag string) (time.Time, time.Time, error) {
+	if fromFlag == "" || toFlag == "" {
+		return time.Time{}, time.Time{}, errors.New("both -from and -to must be set to export a range")
+	}
+	from, err := time.Parse("2006-01-02", fromFlag)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid -from date: %w", err)
+	}
+	to, err := time.Parse("2006-01-02", toFlag)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid -to date: %w", err)
+	}
+	if to.Before(from) {
+		return time.Time{}, time.Time{}, errors.New("-to must not be before -from")
+	}
+	return from, to.AddDate(0, 0, 1).Add(-time.Second), nil
+}
+
+// parseDays parses the -days flag, a "start:end" range of dates within a
+// single month, or a single date. Like parseRange the end is inclusive.
+func parseDays(daysFlag string) (time.Time, time.Time, error) {
+	start, end, found := strings.Cut(daysFlag, ":")
+	if !found {
+		end = start
+	}
+	from, to, err := parseRange(start, end)
+	if err != nil {
+		return from, to, err
+	}
+	if from.Year() != to.Year() || from.Month() != to.Month() {
+		return from, to, errors.New("-days must stay within one month, use -from/-to for longer ranges")
+	}
+	return from, to, nil
+}
+
+// exportFileSuffixes are the endings of the files an export writes besides
+// the per-session JSON metadata, telling an earlier export apart from a
+// directory that only holds the bookkeeping of runs.
+var exportFileSuffixes = []string{".avi", ".ndjson", ".parquet", ".tar.gz", ".zip"}
+
+// newestExportedStart returns the Start of the newest recording whose
+// metadata is stored below dir, or the zero time if dir is missing or holds
+// no export. An export no Start can be read from is an error, as starting
+// over would export everything again.
+func newestExportedStart(dir string) (time.Time, error) {
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return time.Time{}, nil
+	}
+	existing, err := pvwaAPI.LoadRecordings(dir)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var newest int64
+	for _, r := range existing.Recordings {
+		newest = max(newest, r.Start)
+	}
+	if newest > 0 {
+		return time.Unix(newest, 0).UTC(), nil
+	}
+
+	exported := len(existing.Recordings) > 0
+	if !exported {
+		err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			for _, suffix := range exportFileSuffixes {
+				if info.Mode().IsRegular() && strings.HasSuffix(path, suffix) {
+					exported = true
+					return filepath.SkipAll
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return time.Time{}, fmt.Errorf("error reading earlier export: %w", err)
+		}
+	}
+	if exported {
+		return time.Time{}, fmt.Errorf("%s holds an earlier export without the Start of its recordings "+
+			"(as written with -archive-remove, -json-mode none or -fields without Start), "+
+			"-since-output can't continue it; select the window with -months or -from and -to", dir)
+	}
+	return time.Time{}, nil
+}
+
+// skipFutureMonths drops the months of year that start after now, as they
+// can't hold any recordings yet, and warns about each of them. With strict
+// a future month is an error instead.
+func skipFutureMonths(months []int, year int, now time.Time, strict bool) ([]int, error) {
+	kept := make([]int, 0, len(months))
+	for _, m := range months {
+		from := time.Date(year, time.Month(m), 1, 0, 0, 0, 0, time.UTC)
+		if !from.After(now) {
+			kept = append(kept, m)
+			continue
+		}
+		if strict {
+			return nil, fmt.Errorf("month %d starts in the future (%s)", m, from.Format(time.DateOnly))
+		}
+		slog.Warn("skipping month in the future", "month", m, "starts", from.Format(time.DateOnly))
+	}
+	if len(kept) == 0 {
+		return nil, errors.New("all selected months are in the future")
+	}
+	return kept, nil
 }
 
-func parseMonths(monthsFlag string) []int {
+func parseMonths(monthsFlag string) ([]int, error) {
 	var months []int
 
 	if strings.Contains(monthsFlag, "-") {
 		// Handle range format (e.g. "1-12")
 		parts := strings.Split(monthsFlag, "-")
 		if len(parts) != 2 {
-			log.Fatal("invalid month range format. Use 'start-end' (e.g. '1-12')")
+			return nil, errors.New("invalid month range format. Use 'start-end' (e.g. '1-12')")
 		}
 		start, err := strconv.Atoi(parts[0])
 		if err != nil {
-			log.Fatal("invalid start month:", err)
+			return nil, fmt.Errorf("invalid start month: %w", err)
 		}
 		end, err := strconv.Atoi(parts[1])
 		if err != nil {
-			log.Fatal("invalid end month:", err)
+			return nil, fmt.Errorf("invalid end month: %w", err)
 		}
 		for i := start; i <= end; i++ {
 			if i < 1 || i > 12 {
-				log.Fatal("months must be between 1 and 12")
+				return nil, errors.New("months must be between 1 and 12")
 			}
 			months = append(months, i)
 		}
@@ -87,13 +1271,13 @@ func parseMonths(monthsFlag string) []int {
 		for _, m := range strings.Split(monthsFlag, ",") {
 			month, err := strconv.Atoi(strings.TrimSpace(m))
 			if err != nil {
-				log.Fatal("invalid month:", err)
+				return nil, fmt.Errorf("invalid month: %w", err)
 			}
 			if month < 1 || month > 12 {
-				log.Fatal("months must be between 1 and 12")
+				return nil, errors.New("months must be between 1 and 12")
 			}
 			months = append(months, month)
 		}
 	}
-	return months
+	return months, nil
 }
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"export-recordings/api"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// secretFlags are left out of the run parameters.
+var secretFlags = []string{"username"}
+
+// redactedFlags can carry credentials in their value, which is replaced by
+// flagValue wherever flags are written down: userinfo in -baseURL, and
+// tokens commonly passed to the post-download hook.
+var redactedFlags = map[string]func(string) string{
+	"baseURL":            redactURL,
+	"post-download-hook": redactValue,
+}
+
+// flagValue returns the value of f as it may be written to the run
+// parameters, the audit log and the effective configuration.
+func flagValue(f *flag.Flag) string {
+	if redact, ok := redactedFlags[f.Name]; ok {
+		return redact(f.Value.String())
+	}
+	return f.Value.String()
+}
+
+// redactURL strips the userinfo from rawURL.
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.User == nil {
+		return rawURL
+	}
+	u.User = nil
+	return u.String()
+}
+
+// redactValue hides a set value entirely, keeping only whether it was set.
+func redactValue(value string) string {
+	if value == "" {
+		return ""
+	}
+	return "REDACTED"
+}
+
+// runParameters describes how an export was generated, so that it can be
+// reproduced. Unlike the audit log it holds every effective setting,
+// including defaults, and the resolved windows of all periods.
+type runParameters struct {
+	RunID   string    `json:"run_id"`
+	Created time.Time `json:"created"`
+	// CommandLine holds the explicitly set flags as arguments
+	CommandLine []string `json:"command_line"`
+	// Flags holds the value of every flag, set or default
+	Flags   map[string]string `json:"flags"`
+	Periods []runPeriod       `json:"periods"`
+}
+
+// runPeriod is the resolved window of one exported period. From and To are
+// empty for -latest, which has no fixed window.
+type runPeriod struct {
+	Name string `json:"name"`
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+}
+
+// writeRunParameters writes the parameters of the run to path, leaving out
+// secretFlags and redacting redactedFlags. In WORM mode, where an earlier file can't be replaced, the
+// run ID is added to the name.
+func writeRunParameters(path string, runID string, fs *flag.FlagSet, periods []exportPeriod) error {
+	params := runParameters{
+		RunID:       runID,
+		Created:     time.Now().UTC(),
+		CommandLine: []string{},
+		Flags:       make(map[string]string),
+	}
+	fs.VisitAll(func(f *flag.Flag) {
+		if !slices.Contains(secretFlags, f.Name) {
+			params.Flags[f.Name] = flagValue(f)
+		}
+	})
+	fs.Visit(func(f *flag.Flag) {
+		if !slices.Contains(secretFlags, f.Name) {
+			params.CommandLine = append(params.CommandLine, "-"+f.Name+"="+flagValue(f))
+		}
+	})
+	for _, p := range periods {
+		period := runPeriod{Name: p.name}
+		if !p.from.IsZero() {
+			period.From = p.from.UTC().Format(time.RFC3339)
+			period.To = p.to.UTC().Format(time.RFC3339)
+		}
+		params.Periods = append(params.Periods, period)
+	}
+
+	data, err := json.MarshalIndent(params, "", "    ")
+	if err != nil {
+		return fmt.Errorf("error marshaling run parameters: %w", err)
+	}
+	if pvwaAPI.WORM {
+		ext := filepath.Ext(path)
+		path = strings.TrimSuffix(path, ext) + "-" + runID + ext
+	}
+	if err := os.MkdirAll(filepath.Dir(path), pvwaAPI.DirMode); err != nil {
+		return fmt.Errorf("error creating run parameters directory: %w", err)
+	}
+	out, err := pvwaAPI.CreateFile(path)
+	if err != nil {
+		return fmt.Errorf("error creating run parameters: %w", err)
+	}
+	defer out.Close()
+	if _, err := out.Write(data); err != nil {
+		return fmt.Errorf("error writing run parameters: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("error writing run parameters: %w", err)
+	}
+	pvwaAPI.RecordWritten(path)
+	return nil
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"export-recordings/api"
+	"fmt"
+	"io"
+	"log/slog"
+	"text/tabwriter"
+	"time"
+)
+
+// RunResult summarizes what a run exported, so callers can inspect the
+// outcome without parsing the logs.
+type RunResult struct {
+	// RunID is the identifier of the run in the audit log
+	RunID string
+	// CountOnly is set when the run only counted recordings
+	CountOnly bool
+	// Estimate is set when the run only estimated the download size
+	Estimate bool
+	// Periods holds the outcome of every exported period in order
+	Periods []PeriodResult
+	// Recordings is the number of recordings exported (or counted) after
+	// filtering, over all periods
+	Recordings int
+	// VideoSize and TextSize are the sizes reported in the metadata of the
+	// recordings, over all periods. They are only set by estimate runs
+	VideoSize int64
+	TextSize  int64
+	// The download totals over all periods
+	pvwaAPI.DownloadStats
+	// Failures lists the downloads that failed without aborting the run,
+	// including the ones not started before the -max-runtime deadline
+	Failures []pvwaAPI.DownloadFailure
+}
+
+// PeriodResult is the outcome of a single period.
+type PeriodResult struct {
+	Name       string
+	Recordings int
+	// OutputPath is the directory the period's videos were written to,
+	// empty when nothing was written to disk
+	OutputPath string
+	// MetadataPath is the directory the metadata was written to, the same
+	// as OutputPath unless -metadata-output or -video-output is used
+	MetadataPath string
+	// VideoSize and TextSize are only set by estimate runs
+	VideoSize int64
+	TextSize  int64
+	// APITotal is the number of recordings the API reported for the
+	// period, Retrieved the number actually retrieved and Selected the
+	// number left to download after filters and selections
+	APITotal  int
+	Retrieved int
+	Selected  int
+	pvwaAPI.DownloadStats
+}
+
+// add appends period to the result and adds it to the totals.
+func (r *RunResult) add(period PeriodResult) {
+	r.Periods = append(r.Periods, period)
+	r.Recordings += period.Recordings
+	r.VideoSize += period.VideoSize
+	r.TextSize += period.TextSize
+	r.Downloaded += period.Downloaded
+	r.Skipped += period.Skipped
+	r.Failed += period.Failed
+	r.Bytes += period.Bytes
+	r.SizeMismatches += period.SizeMismatches
+}
+
+// reconcile logs whether everything the API reported for the period was
+// retrieved, and everything selected after filtering was downloaded or
+// already existed, with a warning when either doesn't add up.
+func (p PeriodResult) reconcile() {
+	complete := p.Retrieved == p.APITotal && p.Downloaded+p.Skipped == p.Selected
+	logf := slog.Info
+	if !complete {
+		logf = slog.Warn
+	}
+	logf("reconciliation",
+		"period", p.Name,
+		"complete", complete,
+		"apiTotal", p.APITotal,
+		"retrieved", p.Retrieved,
+		"filteredOut", p.Retrieved-p.Recordings,
+		"deselected", p.Recordings-p.Selected,
+		"selected", p.Selected,
+		"downloaded", p.Downloaded,
+		"skipped", p.Skipped,
+		"failed", p.Failed)
+}
+
+// log writes a summary of the export to the log. Count-only and estimate
+// runs have already printed their results and log nothing.
+func (r *RunResult) log() {
+	if r == nil || r.CountOnly || r.Estimate {
+		return
+	}
+	for _, p := range r.Periods {
+		slog.Info("period summary",
+			"period", p.Name,
+			"recordings", p.Recordings,
+			"downloaded", p.Downloaded,
+			"skipped", p.Skipped,
+			"failed", p.Failed,
+			"sizeMismatches", p.SizeMismatches,
+			"bytes", p.Bytes)
+	}
+	slog.Info("export summary",
+		"runID", r.RunID,
+		"periods", len(r.Periods),
+		"recordings", r.Recordings,
+		"downloaded", r.Downloaded,
+		"skipped", r.Skipped,
+		"failed", r.Failed,
+		"sizeMismatches", r.SizeMismatches,
+		"bytes", r.Bytes)
+}
+
+// writeCountsJSON writes the counts of a count-only run to w as a single
+// JSON object.
+func (r *RunResult) writeCountsJSON(w io.Writer) error {
+	type periodCount struct {
+		Period     string `json:"period"`
+		Recordings int    `json:"recordings"`
+	}
+	var counts struct {
+		Periods []periodCount `json:"periods"`
+		Total   int           `json:"total"`
+	}
+	for _, p := range r.Periods {
+		counts.Periods = append(counts.Periods, periodCount{Period: p.Name, Recordings: p.Recordings})
+	}
+	counts.Total = r.Recordings
+	if err := json.NewEncoder(w).Encode(counts); err != nil {
+		return fmt.Errorf("error writing counts: %w", err)
+	}
+	return nil
+}
+
+// writeCountsTable writes the counts of a count-only run to w as a table.
+func (r *RunResult) writeCountsTable(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PERIOD\tRECORDINGS")
+	for _, p := range r.Periods {
+		fmt.Fprintf(tw, "%s\t%d\n", p.Name, p.Recordings)
+	}
+	fmt.Fprintf(tw, "total\t%d\n", r.Recordings)
+	tw.Flush()
+}
+
+// estimatedDuration is how long downloading size bytes takes at throughput
+// bytes per second.
+func estimatedDuration(size int64, throughput float64) time.Duration {
+	return time.Duration(float64(size) / throughput * float64(time.Second)).Round(time.Second)
+}
+
+// writeEstimateJSON writes the sizes of an estimate run to w as a single
+// JSON object, with the durations in seconds at throughput bytes per
+// second.
+func (r *RunResult) writeEstimateJSON(w io.Writer, throughput float64) error {
+	type periodEstimate struct {
+		Period     string  `json:"period"`
+		Recordings int     `json:"recordings"`
+		VideoBytes int64   `json:"video_bytes"`
+		TextBytes  int64   `json:"text_bytes"`
+		Seconds    float64 `json:"estimated_seconds"`
+	}
+	var estimate struct {
+		Periods    []periodEstimate `json:"periods"`
+		Total      periodEstimate   `json:"total"`
+		Throughput float64          `json:"throughput_bytes_per_second"`
+	}
+	for _, p := range r.Periods {
+		estimate.Periods = append(estimate.Periods, periodEstimate{
+			Period:     p.Name,
+			Recordings: p.Recordings,
+			VideoBytes: p.VideoSize,
+			TextBytes:  p.TextSize,
+			Seconds:    float64(p.VideoSize+p.TextSize) / throughput,
+		})
+	}
+	estimate.Total = periodEstimate{
+		Period:     "total",
+		Recordings: r.Recordings,
+		VideoBytes: r.VideoSize,
+		TextBytes:  r.TextSize,
+		Seconds:    float64(r.VideoSize+r.TextSize) / throughput,
+	}
+	estimate.Throughput = throughput
+	if err := json.NewEncoder(w).Encode(estimate); err != nil {
+		return fmt.Errorf("error writing estimate: %w", err)
+	}
+	return nil
+}
+
+// writeEstimateTable writes the sizes of an estimate run to w as a table,
+// with the durations at throughput bytes per second.
+func (r *RunResult) writeEstimateTable(w io.Writer, throughput float64) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PERIOD\tRECORDINGS\tVIDEO\tTEXT\tDURATION")
+	row := func(name string, recordings int, video int64, text int64) {
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\n", name, recordings,
+			pvwaAPI.FormatBytes(float64(video)), pvwaAPI.FormatBytes(float64(text)), estimatedDuration(video+text, throughput))
+	}
+	for _, p := range r.Periods {
+		row(p.Name, p.Recordings, p.VideoSize, p.TextSize)
+	}
+	row("total", r.Recordings, r.VideoSize, r.TextSize)
+	tw.Flush()
+	fmt.Fprintf(w, "Durations assume %s/s\n", pvwaAPI.FormatBytes(throughput))
+}
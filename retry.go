@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bufio"
+	"export-recordings/api"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// writeRetryFile writes the SessionIDs of failures to path, one per line,
+// in the format readSessionsFile expects. Without failures a retry file
+// left by an earlier run is removed so it is never retried by mistake,
+// except in WORM mode where nothing is ever removed.
+func writeRetryFile(path string, failures []pvwaAPI.DownloadFailure) error {
+	if len(failures) == 0 {
+		if pvwaAPI.WORM {
+			return nil
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error removing retry file: %w", err)
+		}
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), pvwaAPI.DirMode); err != nil {
+		return fmt.Errorf("error creating retry file directory: %w", err)
+	}
+	var b strings.Builder
+	b.WriteString("# SessionIDs that failed to download or weren't started before the deadline, retry with -sessions-file\n")
+	for _, f := range failures {
+		b.WriteString(f.SessionID + "\n")
+	}
+
+	out, err := pvwaAPI.CreateFile(path)
+	if err != nil {
+		return fmt.Errorf("error creating retry file: %w", err)
+	}
+	defer out.Close()
+	if _, err := out.WriteString(b.String()); err != nil {
+		return fmt.Errorf("error writing retry file: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("error writing retry file: %w", err)
+	}
+	pvwaAPI.RecordWritten(path)
+	return nil
+}
+
+// readSessionsFile reads a list of SessionIDs, one per line. Empty lines
+// and lines starting with # are ignored.
+func readSessionsFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening sessions file: %w", err)
+	}
+	defer f.Close()
+
+	var ids []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		ids = append(ids, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading sessions file: %w", err)
+	}
+	return ids, nil
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"export-recordings/api"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// sealPrefix starts the names of seal files, which are never sealed
+// themselves.
+const sealPrefix = "SEAL"
+
+// periodSeal attests the files of one sealed period. SHA256 is the hash of
+// the listing file, which holds the hash of every sealed file.
+type periodSeal struct {
+	RunID      string    `json:"run_id"`
+	Period     string    `json:"period"`
+	From       string    `json:"from,omitempty"`
+	To         string    `json:"to,omitempty"`
+	Created    time.Time `json:"created"`
+	Recordings int       `json:"recordings"`
+	Files      int       `json:"files"`
+	Bytes      int64     `json:"bytes"`
+	Listing    string    `json:"listing"`
+	SHA256     string    `json:"sha256"`
+}
+
+// sealPeriod seals the files of a finished period: sources are the period
+// directories, or the archives when the period was archived. It writes
+// a listing in sha256sum format of every file, relative to the seal's
+// directory and sorted by path, and a JSON attestation holding the SHA-256
+// of that listing as the single hash of the period. The seal is written
+// into dir as SEAL.sha256 and SEAL.json, or as <prefix>.SEAL.sha256 and
+// <prefix>.SEAL.json when prefix is set. In WORM mode the run ID is added
+// to the names.
+func sealPeriod(dir string, prefix string, sources []string, period exportPeriod, recordings int, runID string) (string, error) {
+	var files []string
+	for _, source := range sources {
+		found, err := sealedFiles(source)
+		if err != nil {
+			return "", err
+		}
+		files = append(files, found...)
+	}
+
+	type entry struct{ path, sum string }
+	entries := make([]entry, 0, len(files))
+	var size int64
+	for _, path := range files {
+		sum, n, err := hashFile(path)
+		if err != nil {
+			return "", err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			rel = path
+		}
+		entries = append(entries, entry{filepath.ToSlash(rel), sum})
+		size += n
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].path < entries[j].path })
+
+	var listing strings.Builder
+	for _, e := range entries {
+		fmt.Fprintf(&listing, "%s  %s\n", e.sum, e.path)
+	}
+
+	name := sealPrefix
+	if prefix != "" {
+		name = prefix + "." + sealPrefix
+	}
+	if pvwaAPI.WORM {
+		name += "-" + runID
+	}
+	sum := sha256.Sum256([]byte(listing.String()))
+	seal := periodSeal{
+		RunID:      runID,
+		Period:     period.name,
+		Created:    time.Now().UTC(),
+		Recordings: recordings,
+		Files:      len(entries),
+		Bytes:      size,
+		Listing:    name + ".sha256",
+		SHA256:     hex.EncodeToString(sum[:]),
+	}
+	if !period.from.IsZero() {
+		seal.From = period.from.UTC().Format(time.RFC3339)
+		seal.To = period.to.UTC().Format(time.RFC3339)
+	}
+	data, err := json.MarshalIndent(seal, "", "    ")
+	if err != nil {
+		return "", fmt.Errorf("error marshaling seal: %w", err)
+	}
+
+	if err := os.MkdirAll(dir, pvwaAPI.DirMode); err != nil {
+		return "", fmt.Errorf("error creating seal directory: %w", err)
+	}
+	if err := writeSealFile(filepath.Join(dir, seal.Listing), []byte(listing.String())); err != nil {
+		return "", err
+	}
+	attestation := filepath.Join(dir, name+".json")
+	if err := writeSealFile(attestation, data); err != nil {
+		return "", err
+	}
+	slog.Info("sealed period",
+		"period", period.name,
+		"seal", attestation,
+		"files", seal.Files,
+		"sha256", seal.SHA256)
+	return attestation, nil
+}
+
+// sealedFiles returns the regular files below source, or source itself
+// when it is a file. Seal files and .partial leftovers of failed downloads
+// are left out.
+func sealedFiles(source string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(source, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() || strings.HasPrefix(d.Name(), sealPrefix) ||
+			strings.HasSuffix(d.Name(), ".partial") {
+			return nil
+		}
+		files = append(files, path)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing files to seal: %w", err)
+	}
+	return files, nil
+}
+
+// hashFile returns the SHA-256 and size of the file at path.
+func hashFile(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, fmt.Errorf("error opening %s: %w", path, err)
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	n, err := io.Copy(hash, f)
+	if err != nil {
+		return "", 0, fmt.Errorf("error hashing %s: %w", path, err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), n, nil
+}
+
+// writeSealFile writes data to a new file at path and records it for the
+// manifest.
+func writeSealFile(path string, data []byte) error {
+	out, err := pvwaAPI.CreateFile(path)
+	if err != nil {
+		return fmt.Errorf("error creating seal: %w", err)
+	}
+	defer out.Close()
+	if _, err := out.Write(data); err != nil {
+		return fmt.Errorf("error writing seal: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("error writing seal: %w", err)
+	}
+	pvwaAPI.RecordWritten(path)
+	return nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// exitInterrupted is the exit code of a run stopped by SIGINT or SIGTERM,
+// following the shell convention of 128 plus the signal number of SIGINT.
+const exitInterrupted = 130
+
+// flushOnInterrupt calls flush when the process receives SIGINT or SIGTERM
+// and exits with exitInterrupted afterwards. mu is locked before flush is
+// called and never unlocked, so the run can neither change what is being
+// flushed nor carry on once it is written. The returned function stops
+// watching for signals and may be called more than once.
+func flushOnInterrupt(mu *sync.Mutex, flush func()) func() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case sig := <-signals:
+			slog.Warn("interrupted, saving the state of the run", "signal", sig)
+			mu.Lock()
+			flush()
+			os.Exit(exitInterrupted)
+		case <-done:
+		}
+	}()
+	return sync.OnceFunc(func() {
+		signal.Stop(signals)
+		close(done)
+	})
+}